@@ -2,14 +2,13 @@ package main
 
 import (
 	"encoding/json"
-	"os"
 	"regexp"
 	"time"
 
-	_ "github.com/joho/godotenv/autoload"
 	"github.com/nats-io/nats.go"
 
 	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
 	"github.com/nicklasfrahm/showcases/pkg/service"
 )
 
@@ -22,24 +21,29 @@ var (
 	channelsRegExp = regexp.MustCompile(`^channels\..*`)
 )
 
+// Config contains the configuration of the service.
+type Config struct {
+	config.Service
+	config.Broker
+}
+
 func main() {
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
+
 	// Create new service instance.
-	svc := service.New(service.Config{
-		Name:    name,
-		Version: version,
-	})
+	svc := service.New(cfg.ServiceConfig(name, version))
 
 	// Configure broker connection.
-	svc.UseBroker(broker.NewNATS(&broker.NATSOptions{
-		URI: os.Getenv("BROKER_URI"),
-		NATSOptions: []nats.Option{
-			nats.Name(name),
-			nats.Timeout(1 * time.Second),
-			nats.PingInterval(5 * time.Second),
-			nats.MaxPingsOutstanding(6),
-		},
-		RequestTimeout: 20 * time.Millisecond,
-	}))
+	natsOptions := cfg.NATSOptions()
+	natsOptions.NATSOptions = []nats.Option{
+		nats.Name(name),
+		nats.Timeout(1 * time.Second),
+		nats.PingInterval(5 * time.Second),
+		nats.MaxPingsOutstanding(6),
+	}
+	svc.UseBroker(broker.NewNATS(natsOptions))
 
 	// Define catch-all channel for audit service.
 	svc.BrokerChannel(">", func(ctx *service.Context) error {
@@ -47,8 +51,9 @@ func main() {
 
 		// Omit logging the content for the following channels:
 		// - response
+		// - error
 		// - channels.*
-		if channel == "response" || channelsRegExp.MatchString(channel) {
+		if channel == service.EventTypeResponse || channel == service.EventTypeError || channelsRegExp.MatchString(channel) {
 			return nil
 		}
 
@@ -60,7 +65,7 @@ func main() {
 
 		if data == nil {
 			// There is no data. Just log the subject.
-			ctx.Service.Logger.Info().Msgf("%s", channel)
+			ctx.Logger().Info().Msgf("%s", channel)
 			return nil
 		}
 
@@ -69,7 +74,7 @@ func main() {
 			return err
 		}
 
-		ctx.Service.Logger.Info().Msgf("%s\n%s", channel, string(encoded))
+		ctx.Logger().Info().Msgf("%s\n%s", channel, string(encoded))
 		return nil
 	})
 
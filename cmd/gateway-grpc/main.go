@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+var (
+	name    = "unknown"
+	version = "dev"
+)
+
+// Config contains the configuration of the gateway.
+type Config struct {
+	config.Service
+	config.Broker
+	config.Auth
+
+	Port string `env:"PORT"`
+}
+
+func main() {
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
+
+	// Create new service instance.
+	svc := service.New(cfg.ServiceConfig(name, version))
+
+	// Load the credentials, the token validation, the policy and the API
+	// keys. If no policy is configured, all authenticated users may access
+	// all channels.
+	authenticator, policy, err := cfg.Auth.Load()
+	if err != nil {
+		svc.Logger.Fatal().Err(err).Msg("Failed to load authentication")
+	}
+
+	// Configure broker connection.
+	svc.UseBroker(broker.NewNATS(cfg.NATSOptions()))
+
+	// Configure gateway.
+	svc.UseGateway(gateway.NewGRPC(gateway.Port(cfg.Port)))
+
+	svc.GatewayMiddleware(AuthN(authenticator))
+	svc.GatewayMiddleware(AuthZ(policy, authenticator.APIKeys))
+
+	// Wait until error occurs or signal is received.
+	svc.Start()
+}
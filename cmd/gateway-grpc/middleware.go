@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+const (
+	LocalsIdentity = "identity"
+
+	MetadataAuthorization = "authorization"
+	MetadataAPIKey        = "x-api-key"
+)
+
+// AuthN authenticates the call via its metadata, which carries
+// the same credentials as the HTTP headers.
+func AuthN(authenticator *auth.Authenticator) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.GRPCContext)
+
+		identity, err := authenticator.Authenticate(ctx.Get(MetadataAuthorization), ctx.Get(MetadataAPIKey))
+		if err == auth.ErrMissingCredentials {
+			return errs.MissingCredentials
+		}
+		if err != nil {
+			return errs.InvalidCredentials
+		}
+
+		// Forward the caller to the backend services and
+		// log its requests with the channel and its subject.
+		ctx.Locals(LocalsIdentity, identity)
+		logger := r.Logger().With().
+			Str(service.LogFieldChannel, ctx.Channel).
+			Str(service.LogFieldUser, identity.Subject).
+			Logger()
+		ctx.SetUserContext(service.WithLogger(identity.WithContext(ctx.UserContext()), &logger))
+
+		return ctx.Next()
+	}
+}
+
+// AuthZ authorizes the caller to access the channel or pattern and
+// counts invocations against the quota of API keys.
+func AuthZ(policy *auth.Policy, apiKeys *auth.APIKeys) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.GRPCContext)
+		identity := ctx.Locals(LocalsIdentity).(*auth.Identity)
+
+		// Patterns of subscriptions may contain wildcards and must therefore
+		// be fully covered by the channels that the caller may access.
+		authorize := gateway.Authorize
+		if ctx.Method == gateway.GRPCMethodSubscribe {
+			authorize = gateway.AuthorizePattern
+		}
+		if err := authorize(r.Logger(), policy, identity, ctx.Channel); err != nil {
+			return err
+		}
+
+		if ctx.Method == gateway.GRPCMethodInvoke {
+			if _, err := gateway.ConsumeQuota(apiKeys, identity); err != nil {
+				return err
+			}
+		}
+
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// KeysFind replies with the usage of all API keys, which is only revealed to
+// callers whose token contains the role. As the usage is counted by each
+// instance of the gateway, it only covers the replying instance.
+func KeysFind(apiKeys *auth.APIKeys, role string) service.ChannelHandler {
+	return func(ctx *service.Context) error {
+		if !hasRole(auth.Claims(ctx.Claims()).Roles(), role) {
+			return errs.InvalidCredentials
+		}
+
+		return ctx.Reply(apiKeys.Usage())
+	}
+}
+
+// hasRole checks if the roles contain the role.
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
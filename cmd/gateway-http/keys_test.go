@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+func TestKeysFind(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(file, []byte(`{"keys":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	apiKeys, err := auth.LoadAPIKeys(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := KeysFind(apiKeys, "admin")
+
+	tests := []struct {
+		name   string
+		claims string
+		err    error
+	}{
+		{name: "admin role", claims: `{"sub":"alice","roles":["admin"]}`},
+		{name: "other role", claims: `{"sub":"bob","roles":["reader"]}`, err: errs.InvalidCredentials},
+		{name: "without token", err: errs.InvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := cloudevents.NewEvent()
+			if tt.claims != "" {
+				event.SetExtension(service.ExtensionAuthClaims, tt.claims)
+			}
+
+			if err := handler(&service.Context{Cloudevent: &event}); err != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"net/http"
-	"os"
-	"strings"
-	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
 
 	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
 	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/ratelimit"
 	"github.com/nicklasfrahm/showcases/pkg/service"
 )
 
@@ -25,36 +27,153 @@ var (
 	}
 )
 
+// Config contains the configuration of the gateway.
+type Config struct {
+	config.Service
+	config.Broker
+	config.Auth
+
+	Port string `env:"PORT"`
+	// TrustedProxies is a comma-separated list of the addresses or
+	// networks of proxies, whose `X-Forwarded-For` header is trusted.
+	TrustedProxies []string `env:"TRUSTED_PROXIES"`
+	// APIKeysRole is the role of the token that is required
+	// to read the usage of the API keys via the gateway.
+	APIKeysRole string `env:"API_KEYS_ROLE" default:"admin"`
+
+	RateLimit          string `env:"RATE_LIMIT"`
+	RateLimitOverrides string `env:"RATE_LIMIT_OVERRIDES"`
+	RateLimitAuthN     string `env:"RATE_LIMIT_AUTHN"`
+	RateLimitRedisURI  string `env:"RATE_LIMIT_REDIS_URI"`
+
+	// SSEChannels is a comma-separated list of the channel
+	// patterns that can be streamed via Server-Sent Events.
+	SSEChannels []string `env:"SSE_CHANNELS"`
+	SSERedisURI string   `env:"SSE_REDIS_URI"`
+}
+
 func main() {
-	// Load authorized users.
-	users := make(map[string]string)
-	usersCreds := strings.Split(os.Getenv("AUTHORIZED_CREDENTIALS"), ",")
-	for _, userCred := range usersCreds {
-		userPass := strings.Split(userCred, ":")
-		if len(userPass) == 2 {
-			users[userPass[0]] = userPass[1]
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
+
+	// Create new service instance.
+	svc := service.New(cfg.ServiceConfig(name, version))
+
+	// Load the credentials, the token validation, the policy and the API
+	// keys. If no policy is configured, all authenticated users may access
+	// all channels.
+	authenticator, policy, err := cfg.Auth.Load()
+	if err != nil {
+		svc.Logger.Fatal().Err(err).Msg("Failed to load authentication")
+	}
+	apiKeys := authenticator.APIKeys
+
+	// Resolve the client IP via the headers of trusted proxies.
+	trustedProxies, err := ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		svc.Logger.Fatal().Err(err).Msg("Failed to parse trusted proxies")
+	}
+
+	// Configure rate limiting if a limit is provided. The buckets are
+	// shared via Redis if multiple instances of the gateway are deployed.
+	var store ratelimit.Store
+	if cfg.RateLimitRedisURI != "" {
+		redisOptions, err := redis.ParseURL(cfg.RateLimitRedisURI)
+		if err != nil {
+			svc.Logger.Fatal().Err(err).Msg("Failed to parse rate limit store")
 		}
+		store = ratelimit.NewRedis(&ratelimit.RedisOptions{
+			Client: redis.NewClient(redisOptions),
+		})
 	}
 
-	// Create new service instance.
-	svc := service.New(service.Config{
-		Name:    name,
-		Version: version,
-	})
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit != "" {
+		limit, err := ratelimit.ParseLimit(cfg.RateLimit)
+		if err != nil {
+			svc.Logger.Fatal().Err(err).Msg("Failed to parse rate limit")
+		}
+		overrides, err := ratelimit.ParseOverrides(cfg.RateLimitOverrides)
+		if err != nil {
+			svc.Logger.Fatal().Err(err).Msg("Failed to parse rate limit overrides")
+		}
+
+		limiter = ratelimit.NewLimiter(&ratelimit.LimiterOptions{
+			Limit:     limit,
+			Overrides: overrides,
+			Store:     store,
+		})
+	}
+
+	// Failed authentications are limited per client IP by a separate limit.
+	var authNLimiter *ratelimit.Limiter
+	if cfg.RateLimitAuthN != "" {
+		limit, err := ratelimit.ParseLimit(cfg.RateLimitAuthN)
+		if err != nil {
+			svc.Logger.Fatal().Err(err).Msg("Failed to parse authentication rate limit")
+		}
+
+		authNLimiter = ratelimit.NewLimiter(&ratelimit.LimiterOptions{
+			Limit: limit,
+			Store: store,
+		})
+	}
+
+	// Configure the channels that can be streamed via Server-Sent Events.
+	// The events are recorded in Redis if multiple instances are deployed.
+	var sse service.RequestHandler
+	if len(cfg.SSEChannels) > 0 {
+		var store gateway.EventStore = gateway.NewMemoryEventStore(0)
+		if cfg.SSERedisURI != "" {
+			redisOptions, err := redis.ParseURL(cfg.SSERedisURI)
+			if err != nil {
+				svc.Logger.Fatal().Err(err).Msg("Failed to parse event store")
+			}
+			store = gateway.NewRedisEventStore(&gateway.RedisEventStoreOptions{
+				Client: redis.NewClient(redisOptions),
+			})
+		}
+
+		sse = gateway.ServerSentEvents(&gateway.SSEOptions{
+			Channels: cfg.SSEChannels,
+			Authorize: func(r *service.Request, pattern string) error {
+				return gateway.AuthorizePattern(r.Logger(), policy, callerFromLocals(r.Context.(*fiber.Ctx)).identity(), pattern)
+			},
+			Store: store,
+		})
+	}
 
 	// Configure broker connection.
-	svc.UseBroker(broker.NewNATS(&broker.NATSOptions{
-		URI:            os.Getenv("BROKER_URI"),
-		RequestTimeout: 1 * time.Second,
-	}))
+	svc.UseBroker(broker.NewNATS(cfg.NATSOptions()))
 
 	// Configure gateway.
-	svc.UseGateway(gateway.NewHTTP(gateway.Port(os.Getenv("PORT"))))
+	svc.UseGateway(gateway.NewHTTP(gateway.Port(cfg.Port)))
 
+	svc.GatewayMiddleware(ClientIP(trustedProxies))
 	svc.GatewayMiddleware(NormalizeProtoToChannel())
-	svc.GatewayMiddleware(AuthN(users))
+	svc.GatewayMiddleware(Tracing())
+	svc.GatewayMiddleware(Logging())
+	if authNLimiter != nil {
+		svc.GatewayMiddleware(AuthNRateLimit(authNLimiter))
+	}
+	svc.GatewayMiddleware(AuthN(authenticator))
+	// Record metrics only for authenticated clients, which bounds their labels.
+	svc.GatewayMiddleware(Metrics())
+	if limiter != nil {
+		svc.GatewayMiddleware(RateLimit(limiter))
+	}
+	if sse != nil {
+		svc.GatewayMiddleware(sse)
+	}
+	svc.GatewayMiddleware(WebSocket(policy, apiKeys, limiter))
+	svc.GatewayMiddleware(AuthZ(policy, apiKeys))
 	svc.GatewayMiddleware(DispatchToChannel())
 
+	if apiKeys != nil {
+		svc.BrokerChannel("services.gateway.keys.find", KeysFind(apiKeys, cfg.APIKeysRole))
+	}
+
 	// Wait until error occurs or signal is received.
 	svc.Start()
 }
@@ -1,21 +1,133 @@
 package main
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/cloudevents/sdk-go/v2/types"
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
 	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/ratelimit"
 	"github.com/nicklasfrahm/showcases/pkg/service"
 )
 
 const (
+	LocalsIP      = "ip"
 	LocalsChannel = "channel"
 	LocalsType    = "type"
+	LocalsSubject = "subject"
+	LocalsRoles   = "roles"
+	LocalsAPIKey  = "apikey"
+
+	HeaderAPIKey             = "X-API-Key"
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	QueryAPIKey              = "api_key"
+	QueryAccessToken         = "access_token"
+
+	// DefaultMetricsChannelLimit is the number of distinct channels that
+	// are recorded as labels. Further channels are recorded as `other`.
+	DefaultMetricsChannelLimit = 256
+	MetricsChannelOther        = "other"
 )
 
+// ClientIP resolves the IP of the client. Requests of trusted proxies
+// are attributed to the last address in the `X-Forwarded-For` header
+// that is not a trusted proxy, as all addresses before it may be spoofed.
+func ClientIP(trustedProxies []*net.IPNet) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+
+		ctx.Locals(LocalsIP, clientIP(ctx.Context().RemoteIP(), ctx.Get(fiber.HeaderXForwardedFor), trustedProxies))
+
+		return ctx.Next()
+	}
+}
+
+// clientIP returns the address of the client that connected to the first
+// trusted proxy or the remote address if it is not a trusted proxy.
+func clientIP(remote net.IP, forwardedFor string, trustedProxies []*net.IPNet) string {
+	client := remote
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0 && trusted(client, trustedProxies); i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		client = hop
+	}
+
+	return client.String()
+}
+
+// trusted checks if the address belongs to a trusted proxy.
+func trusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTrustedProxies parses the addresses or networks in CIDR notation
+// of trusted proxies.
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// caller describes the authenticated client of a request.
+type caller struct {
+	subject    string
+	roles      []string
+	apiKey     *auth.APIKey
+	ip         string
+	extensions map[string]interface{}
+}
+
+// readRetryPolicy is used to retry reads that time out.
+var readRetryPolicy = &service.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 50 * time.Millisecond,
+	MaxBackoff:     500 * time.Millisecond,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
 func NormalizeProtoToChannel() service.RequestHandler {
 	return func(r *service.Request) error {
 		ctx := r.Context.(*fiber.Ctx)
@@ -56,43 +168,292 @@ func NormalizeProtoToChannel() service.RequestHandler {
 	}
 }
 
-func AuthN(users map[string]string) service.RequestHandler {
+// Tracing starts the span of the request, which continues the trace of
+// the client if it sent a trace context via the `traceparent` header.
+func Tracing() service.RequestHandler {
 	return func(r *service.Request) error {
 		ctx := r.Context.(*fiber.Ctx)
+		channel := ctx.Locals(LocalsChannel).(string)
 
-		// Decode information from request header.
-		authHeader := ctx.Request().Header.Peek("Authorization")
-		authHeaderSegments := strings.Split(string(authHeader), " ")
+		// The path may contain IDs, which are only recorded as target.
+		parent := propagation.TraceContext{}.Extract(ctx.UserContext(), headerCarrier{ctx: ctx})
+		userContext, span := r.Service.Tracer.Start(parent, service.NormalizeChannel(channel),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(ctx.Method()),
+				semconv.HTTPTarget(ctx.OriginalURL()),
+			),
+		)
+		defer span.End()
+		ctx.SetUserContext(userContext)
 
-		// Check if credentials are present with the right authentication scheme.
-		if len(authHeaderSegments) != 2 {
-			return errs.MissingCredentials
+		err := ctx.Next()
+
+		status := responseStatus(ctx, err)
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
 		}
-		authScheme := authHeaderSegments[0]
-		if strings.ToLower(authScheme) != "basic" {
-			return errs.InvalidCredentials
+
+		return err
+	}
+}
+
+// Metrics records the requests by their normalized channel and the status
+// of the response. It must be used after AuthN, such that unauthenticated
+// clients can not create labels, which are additionally limited to the
+// first DefaultMetricsChannelLimit channels.
+func Metrics() service.RequestHandler {
+	labels := &channelLabels{
+		limit: DefaultMetricsChannelLimit,
+		seen:  make(map[string]bool),
+		mutex: &sync.Mutex{},
+	}
+
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+		channel := labels.label(ctx.Locals(LocalsChannel).(string))
+
+		start := time.Now()
+		err := ctx.Next()
+
+		metrics := r.Service.Metrics
+		metrics.GatewayDuration.WithLabelValues(channel).Observe(time.Since(start).Seconds())
+		metrics.GatewayRequests.WithLabelValues(channel, strconv.Itoa(responseStatus(ctx, err))).Inc()
+
+		return err
+	}
+}
+
+// channelLabels limits the number of distinct channel labels.
+type channelLabels struct {
+	limit int
+	seen  map[string]bool
+	mutex *sync.Mutex
+}
+
+// label returns the normalized channel or, once the limit of channels
+// is reached, MetricsChannelOther for channels that were not seen yet.
+func (l *channelLabels) label(channel string) string {
+	normalized := service.NormalizeChannel(channel)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.seen[normalized] {
+		if len(l.seen) >= l.limit {
+			return MetricsChannelOther
 		}
+		l.seen[normalized] = true
+	}
 
-		// Decode the credentials.
-		authCreds, err := base64.StdEncoding.DecodeString(authHeaderSegments[1])
+	return normalized
+}
+
+// responseStatus returns the status of the response. Errors are
+// translated into a status by the error handler afterwards.
+func responseStatus(ctx *fiber.Ctx, err error) int {
+	if err == nil {
+		return ctx.Response().StatusCode()
+	}
+
+	var svcErr *errs.ServiceError
+	if errors.As(err, &svcErr) {
+		return svcErr.Status
+	}
+
+	return errs.UnexpectedError.Status
+}
+
+// Logging enriches the logger of the request with the channel and the
+// trace, such that the logs can be correlated with those of the services.
+func Logging() service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+
+		fields := r.Logger().With().Str(service.LogFieldChannel, ctx.Locals(LocalsChannel).(string))
+		if traceID := service.TraceID(ctx.UserContext()); traceID != "" {
+			fields = fields.Str(service.LogFieldTraceID, traceID)
+		}
+		logger := fields.Logger()
+		ctx.SetUserContext(service.WithLogger(ctx.UserContext(), &logger))
+
+		return ctx.Next()
+	}
+}
+
+// headerCarrier reads the trace context from the headers of the request.
+type headerCarrier struct {
+	ctx *fiber.Ctx
+}
+
+func (c headerCarrier) Get(key string) string {
+	return c.ctx.Get(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c.ctx.Request().Header.Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	return []string{service.ExtensionTraceParent, service.ExtensionTraceState}
+}
+
+func AuthN(authenticator *auth.Authenticator) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+
+		// Prefer API keys, which may be passed as header or query parameter.
+		apiKey := ctx.Get(HeaderAPIKey)
+		if apiKey == "" {
+			apiKey = ctx.Query(QueryAPIKey)
+		}
+
+		// Browsers can not set headers for WebSockets or Server-Sent
+		// Events, which is why tokens may be passed as query parameter.
+		authorization := ctx.Get(fiber.HeaderAuthorization)
+		if token := ctx.Query(QueryAccessToken); token != "" && authorization == "" {
+			authorization = "Bearer " + token
+		}
+
+		identity, err := authenticator.Authenticate(authorization, apiKey)
+		if err == auth.ErrMissingCredentials {
+			return errs.MissingCredentials
+		}
 		if err != nil {
 			return errs.InvalidCredentials
 		}
 
-		credentials := strings.SplitN(string(authCreds), ":", 2)
-		if len(credentials) != 2 {
-			return errs.InvalidCredentials
+		// Forward the caller to the backend services. The channels
+		// and quota of API keys are checked by AuthZ.
+		ctx.Locals(LocalsSubject, identity.Subject)
+		ctx.Locals(LocalsRoles, identity.Roles)
+		if identity.APIKey != nil {
+			ctx.Locals(LocalsAPIKey, identity.APIKey)
 		}
 
-		user := credentials[0]
-		pass := credentials[1]
-		if users[user] != pass {
-			return errs.InvalidCredentials
+		// Log the requests of the caller with its subject.
+		logger := r.Logger().With().Str(service.LogFieldUser, identity.Subject).Logger()
+		ctx.SetUserContext(service.WithLogger(identity.WithContext(ctx.UserContext()), &logger))
+
+		return ctx.Next()
+	}
+}
+
+// AuthZ authorizes the caller to access the channel. If no policy is
+// configured, all authenticated users may access all channels.
+func AuthZ(policy *auth.Policy, apiKeys *auth.APIKeys) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+		c := callerFromLocals(ctx)
+
+		if err := gateway.Authorize(r.Logger(), policy, c.identity(), ctx.Locals(LocalsChannel).(string)); err != nil {
+			return err
+		}
+
+		if usage, err := gateway.ConsumeQuota(apiKeys, c.identity()); err != nil {
+			ctx.Set(fiber.HeaderRetryAfter, seconds(time.Until(usage.Reset)))
+			return err
 		}
+
 		return ctx.Next()
 	}
 }
 
+// callerFromLocals collects the information about the caller stored by AuthN.
+func callerFromLocals(ctx *fiber.Ctx) *caller {
+	c := &caller{
+		extensions: service.ExtensionsFromContext(ctx.UserContext()),
+	}
+	c.ip, _ = ctx.Locals(LocalsIP).(string)
+	if c.ip == "" {
+		c.ip = ctx.IP()
+	}
+	c.subject, _ = ctx.Locals(LocalsSubject).(string)
+	c.roles, _ = ctx.Locals(LocalsRoles).([]string)
+	c.apiKey, _ = ctx.Locals(LocalsAPIKey).(*auth.APIKey)
+
+	return c
+}
+
+// identity returns the identity of the caller.
+func (c *caller) identity() *auth.Identity {
+	return &auth.Identity{Subject: c.subject, Roles: c.roles, APIKey: c.apiKey}
+}
+
+// RateLimit limits the requests of each API key or user. It must be
+// used after AuthN, while client IPs are limited by AuthNRateLimit.
+func RateLimit(limiter *ratelimit.Limiter) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+
+		channel := ctx.Locals(LocalsChannel).(string)
+		result, err := limiter.Take(ctx.UserContext(), rateLimitKey(callerFromLocals(ctx)), channel)
+		if err != nil {
+			// Rather allow requests than failing if the store is unavailable.
+			r.Logger().Warn().Err(err).Msg("Failed to apply rate limit")
+			return ctx.Next()
+		}
+
+		ctx.Set(HeaderRateLimitLimit, strconv.Itoa(result.Limit.Burst))
+		ctx.Set(HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+		ctx.Set(HeaderRateLimitReset, seconds(result.Reset))
+
+		if !result.Allowed {
+			ctx.Set(fiber.HeaderRetryAfter, seconds(result.RetryAfter))
+			return errs.RateLimitExceeded
+		}
+
+		return ctx.Next()
+	}
+}
+
+// AuthNRateLimit limits the failed authentications of each client IP. Only
+// failed authentications take a token, while clients are rejected before
+// their credentials are verified once their bucket is empty, which prevents
+// guessing credentials. It must be used before AuthN.
+func AuthNRateLimit(limiter *ratelimit.Limiter) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+		key := "authn:" + rateLimitKey(callerFromLocals(ctx))
+
+		result, err := limiter.Peek(ctx.UserContext(), key, "")
+		if err != nil {
+			// Rather allow requests than failing if the store is unavailable.
+			r.Logger().Warn().Err(err).Msg("Failed to apply rate limit")
+			return ctx.Next()
+		}
+		if !result.Allowed {
+			ctx.Set(fiber.HeaderRetryAfter, seconds(result.RetryAfter))
+			return errs.RateLimitExceeded
+		}
+
+		err = ctx.Next()
+
+		// Denied access is also an invalid credentials error, which
+		// is why only requests without an authenticated caller count.
+		if errors.Is(err, errs.InvalidCredentials) && ctx.Locals(LocalsSubject) == nil {
+			if _, takeErr := limiter.Take(ctx.UserContext(), key, ""); takeErr != nil {
+				r.Logger().Warn().Err(takeErr).Msg("Failed to apply rate limit")
+			}
+		}
+
+		return err
+	}
+}
+
+// rateLimitKey returns the key of the bucket of the caller.
+func rateLimitKey(c *caller) string {
+	switch {
+	case c.apiKey != nil:
+		return "apikey:" + c.apiKey.ID
+	case c.subject != "":
+		return "user:" + c.subject
+	}
+
+	return "ip:" + c.ip
+}
+
 func DispatchToChannel() service.RequestHandler {
 	return func(r *service.Request) error {
 		ctx := r.Context.(*fiber.Ctx)
@@ -106,17 +467,48 @@ func DispatchToChannel() service.RequestHandler {
 			}
 		}
 
+		// Only retry reads, as other requests might not be idempotent.
+		var retry *service.RetryPolicy
+		if ctx.Method() == http.MethodGet {
+			retry = readRetryPolicy
+		}
+
+		// Abort the request once the deadline of the HTTP request passed.
 		channel := ctx.Locals(LocalsChannel).(string)
-		res, err := r.Service.Broker.Request(channel, body)
+		res, err := r.Service.Broker.RequestContext(ctx.UserContext(), channel, body, service.WithRetry(retry))
 		if err != nil {
 			return errs.InvalidService
 		}
 
-		// TODO: Check the type of the event.
-		_ = res.Cloudevent.Type()
+		// Pass errors of the service to the error middleware.
+		if err := res.Err(); err != nil {
+			return err
+		}
+
+		// Translate the extensions of the response into headers.
+		extensions := res.Cloudevent.Extensions()
+		if location, err := types.ToString(extensions[service.ExtensionLocation]); err == nil {
+			ctx.Set(fiber.HeaderLocation, location)
+		}
+		if etag, err := types.ToString(extensions[service.ExtensionETag]); err == nil {
+			ctx.Set(fiber.HeaderETag, etag)
+		}
+		if lastModified, err := types.ToTime(extensions[service.ExtensionLastModified]); err == nil {
+			ctx.Set(fiber.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+		}
+
+		// Use the status of the service or derive it from the channel.
+		status := gateway.ResponseStatus(channel, res.Cloudevent)
+		if status == http.StatusNoContent {
+			return ctx.SendStatus(status)
+		}
 
-		// TODO: Set HTTP status based on service response.
 		ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
-		return ctx.Send(res.Cloudevent.Data())
+		return ctx.Status(status).Send(res.Cloudevent.Data())
 	}
 }
+
+// seconds formats a duration as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
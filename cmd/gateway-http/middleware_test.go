@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestChannelLabels(t *testing.T) {
+	labels := &channelLabels{limit: 2, seen: make(map[string]bool), mutex: &sync.Mutex{}}
+
+	tests := []struct {
+		channel string
+		label   string
+	}{
+		{channel: "v1.pets.1.read", label: "v1.pets.*.read"},
+		{channel: "v1.pets.2.read", label: "v1.pets.*.read"},
+		{channel: "v1.pets.find", label: "v1.pets.find"},
+		// The limit is reached, but known channels are still recorded.
+		{channel: "v1.toys.find", label: MetricsChannelOther},
+		{channel: "v1.pets.3.read", label: "v1.pets.*.read"},
+	}
+
+	for _, tt := range tests {
+		if label := labels.label(tt.channel); label != tt.label {
+			t.Errorf("expected label %s for %s, got %s", tt.label, tt.channel, label)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remote       string
+		forwardedFor string
+		ip           string
+	}{
+		{name: "direct client", remote: "203.0.113.1", ip: "203.0.113.1"},
+		{name: "untrusted proxy", remote: "203.0.113.1", forwardedFor: "198.51.100.1", ip: "203.0.113.1"},
+		{name: "trusted proxy", remote: "10.0.0.1", forwardedFor: "198.51.100.1", ip: "198.51.100.1"},
+		{name: "trusted proxy without header", remote: "10.0.0.1", ip: "10.0.0.1"},
+		{name: "spoofed header", remote: "10.0.0.1", forwardedFor: "1.1.1.1, 198.51.100.1", ip: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.0.0.1", forwardedFor: "198.51.100.1, 192.168.1.1, 10.0.0.2", ip: "198.51.100.1"},
+		{name: "malformed header", remote: "10.0.0.1", forwardedFor: "unknown", ip: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ip := clientIP(net.ParseIP(tt.remote), tt.forwardedFor, trustedProxies); ip != tt.ip {
+				t.Errorf("expected %s, got %s", tt.ip, ip)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesMalformed(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Fatal("expected malformed proxy to be rejected")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/rs/zerolog"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/ratelimit"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains a WebSocket endpoint, which allows clients to
+// subscribe to channels and to publish events or send requests via
+// JSON frames, for example:
+//
+//   > { "type": "subscribe", "id": "1", "channel": "channels.>" }
+//   < { "type": "ack", "id": "1" }
+//   < { "type": "event", "channel": "channels.>", "event": { ... } }
+//   > { "type": "request", "id": "2", "channel": "v1.services.mail.providers.find" }
+//   < { "type": "response", "id": "2", "event": { ... } }
+//
+// Every frame is authorized like an HTTP request. If a client does not
+// read events fast enough, the connection is closed, while new frames
+// of the client are not read until enough requests have completed.
+
+const (
+	WebSocketPath = "/ws"
+
+	FrameSubscribe   = "subscribe"
+	FrameUnsubscribe = "unsubscribe"
+	FramePublish     = "publish"
+	FrameRequest     = "request"
+	FrameAck         = "ack"
+	FrameEvent       = "event"
+	FrameResponse    = "response"
+	FrameError       = "error"
+
+	// DefaultWebSocketPendingLimit is the number of frames that may
+	// be queued for a client before the connection is closed.
+	DefaultWebSocketPendingLimit = 256
+	// DefaultWebSocketRequestLimit is the number of concurrent
+	// requests of a client before no more frames are read.
+	DefaultWebSocketRequestLimit = 16
+	DefaultWebSocketReadLimit    = 1 << 20
+	DefaultWebSocketPingInterval = 30 * time.Second
+	DefaultWebSocketWriteTimeout = 10 * time.Second
+)
+
+var (
+	ErrSlowConsumer = errors.New("gateway: slow consumer")
+)
+
+// Frame is a message exchanged with a WebSocket client.
+type Frame struct {
+	Type    string             `json:"type"`
+	ID      string             `json:"id,omitempty"`
+	Channel string             `json:"channel,omitempty"`
+	Data    json.RawMessage    `json:"data,omitempty"`
+	Event   *cloudevents.Event `json:"event,omitempty"`
+	Error   *errs.ServiceError `json:"error,omitempty"`
+}
+
+type webSocketConnection struct {
+	service *service.Service
+	logger  *zerolog.Logger
+	policy  *auth.Policy
+	apiKeys *auth.APIKeys
+	limiter *ratelimit.Limiter
+	caller  *caller
+
+	conn          *websocket.Conn
+	closing       <-chan bool
+	subscriptions map[string]service.Subscription
+	outgoing      chan *Frame
+	requests      chan bool
+	done          chan bool
+	written       chan bool
+	closeOnce     *sync.Once
+	mutex         *sync.Mutex
+}
+
+// WebSocket upgrades requests to the WebSocket path. It must be used
+// after AuthN, as the caller of the upgrade request owns the connection.
+func WebSocket(policy *auth.Policy, apiKeys *auth.APIKeys, limiter *ratelimit.Limiter) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+		if ctx.Path() != WebSocketPath || !websocket.IsWebSocketUpgrade(ctx) {
+			return ctx.Next()
+		}
+
+		c := &webSocketConnection{
+			service:       r.Service,
+			logger:        r.Logger(),
+			policy:        policy,
+			apiKeys:       apiKeys,
+			limiter:       limiter,
+			caller:        callerFromLocals(ctx),
+			closing:       gateway.Closing(r.Service),
+			subscriptions: make(map[string]service.Subscription),
+			outgoing:      make(chan *Frame, DefaultWebSocketPendingLimit),
+			requests:      make(chan bool, DefaultWebSocketRequestLimit),
+			done:          make(chan bool),
+			written:       make(chan bool),
+			closeOnce:     &sync.Once{},
+			mutex:         &sync.Mutex{},
+		}
+
+		return websocket.New(c.serve)(ctx)
+	}
+}
+
+// serve reads the frames of the client until the connection is closed.
+func (c *webSocketConnection) serve(conn *websocket.Conn) {
+	c.conn = conn
+	c.logger.Info().Msg("WebSocket connected")
+
+	go c.write()
+	defer c.cleanup()
+
+	conn.SetReadLimit(DefaultWebSocketReadLimit)
+	conn.SetReadDeadline(time.Now().Add(2 * DefaultWebSocketPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * DefaultWebSocketPingInterval))
+	})
+
+	for {
+		frame := new(Frame)
+		if err := conn.ReadJSON(frame); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.send(&Frame{Type: FrameError, Error: errs.InvalidJSON})
+				continue
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * DefaultWebSocketPingInterval))
+
+		c.handle(frame)
+	}
+}
+
+// handle processes a frame of the client.
+func (c *webSocketConnection) handle(frame *Frame) {
+	var err error
+	switch frame.Type {
+	case FrameSubscribe:
+		err = c.subscribe(frame)
+	case FrameUnsubscribe:
+		err = c.unsubscribe(frame)
+	case FramePublish:
+		err = c.publish(frame)
+	case FrameRequest:
+		err = c.request(frame)
+	default:
+		err = errs.InvalidData
+	}
+
+	if err != nil {
+		c.sendError(frame.ID, err)
+	}
+}
+
+func (c *webSocketConnection) subscribe(frame *Frame) error {
+	if frame.Channel == "" {
+		return errs.InvalidEndpoint
+	}
+
+	// The pattern may contain wildcards and must therefore be fully
+	// covered by the channels that the caller may access.
+	if err := gateway.AuthorizePattern(c.logger, c.policy, c.caller.identity(), frame.Channel); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.subscriptions[frame.Channel] == nil {
+		pattern := frame.Channel
+		subscription, err := c.service.Broker.Watch(pattern, func(ctx *service.Context) error {
+			c.send(&Frame{Type: FrameEvent, Channel: pattern, Event: ctx.Cloudevent})
+			return nil
+		})
+		if err != nil {
+			return errs.InvalidService
+		}
+		c.subscriptions[pattern] = subscription
+	}
+
+	return c.send(&Frame{Type: FrameAck, ID: frame.ID})
+}
+
+func (c *webSocketConnection) unsubscribe(frame *Frame) error {
+	c.mutex.Lock()
+	subscription := c.subscriptions[frame.Channel]
+	delete(c.subscriptions, frame.Channel)
+	c.mutex.Unlock()
+
+	if subscription == nil {
+		return errs.InvalidEndpoint
+	}
+	subscription.Unsubscribe()
+
+	return c.send(&Frame{Type: FrameAck, ID: frame.ID})
+}
+
+func (c *webSocketConnection) publish(frame *Frame) error {
+	if err := c.admit(frame.Channel); err != nil {
+		return err
+	}
+
+	if err := c.service.Broker.PublishContext(c.context(), frame.Channel, frame.Data); err != nil {
+		return errs.InvalidService
+	}
+
+	return c.send(&Frame{Type: FrameAck, ID: frame.ID})
+}
+
+// request sends the request in the background, such that the client
+// may send further frames until the limit of requests is reached.
+func (c *webSocketConnection) request(frame *Frame) error {
+	if err := c.admit(frame.Channel); err != nil {
+		return err
+	}
+
+	select {
+	case c.requests <- true:
+	case <-c.done:
+		return nil
+	}
+
+	go func() {
+		defer func() { <-c.requests }()
+
+		res, err := c.service.Broker.RequestContext(c.context(), frame.Channel, frame.Data)
+		if err != nil {
+			c.sendError(frame.ID, errs.InvalidService)
+			return
+		}
+		if err := res.Err(); err != nil {
+			c.sendError(frame.ID, err)
+			return
+		}
+
+		c.send(&Frame{Type: FrameResponse, ID: frame.ID, Event: res.Cloudevent})
+	}()
+
+	return nil
+}
+
+// admit authorizes the caller to send an event to the channel and
+// applies the quota of API keys and the rate limit.
+func (c *webSocketConnection) admit(channel string) error {
+	// Events are sent to a concrete channel, which is authorized as such.
+	if channel == "" || strings.ContainsAny(channel, "*>") {
+		return errs.InvalidEndpoint
+	}
+
+	if err := gateway.Authorize(c.logger, c.policy, c.caller.identity(), channel); err != nil {
+		return err
+	}
+
+	if _, err := gateway.ConsumeQuota(c.apiKeys, c.caller.identity()); err != nil {
+		return err
+	}
+
+	if c.limiter != nil {
+		result, err := c.limiter.Take(context.Background(), rateLimitKey(c.caller), channel)
+		if err != nil {
+			c.logger.Warn().Err(err).Msg("Failed to apply rate limit")
+		} else if !result.Allowed {
+			return errs.RateLimitExceeded
+		}
+	}
+
+	return nil
+}
+
+// context returns a context, which forwards the caller to the backend services.
+func (c *webSocketConnection) context() context.Context {
+	ctx := context.Background()
+	for name, value := range c.caller.extensions {
+		ctx = service.WithExtension(ctx, name, value)
+	}
+
+	return ctx
+}
+
+// send queues a frame for the client without blocking. If the queue
+// is full, the client is too slow and the connection is closed.
+func (c *webSocketConnection) send(frame *Frame) error {
+	select {
+	case c.outgoing <- frame:
+		return nil
+	case <-c.done:
+		return nil
+	default:
+		c.logger.Warn().Msg("WebSocket slow consumer, closing connection")
+		c.close(websocket.ClosePolicyViolation, ErrSlowConsumer.Error())
+		return ErrSlowConsumer
+	}
+}
+
+// sendError sends an error frame as response to the frame with the ID.
+func (c *webSocketConnection) sendError(id string, err error) {
+	var svcErr *errs.ServiceError
+	if !errors.As(err, &svcErr) {
+		svcErr = errs.UnexpectedError
+	}
+
+	c.send(&Frame{Type: FrameError, ID: id, Error: svcErr})
+}
+
+// write sends the queued frames and pings to the client.
+func (c *webSocketConnection) write() {
+	ticker := time.NewTicker(DefaultWebSocketPingInterval)
+	defer ticker.Stop()
+	defer close(c.written)
+
+	for {
+		select {
+		case frame := <-c.outgoing:
+			c.conn.SetWriteDeadline(time.Now().Add(DefaultWebSocketWriteTimeout))
+			if err := c.conn.WriteJSON(frame); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(DefaultWebSocketWriteTimeout)); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-c.closing:
+			// Tell the client to reconnect to another gateway.
+			c.close(websocket.CloseGoingAway, "")
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close sends a close frame and closes the connection, which stops reading.
+func (c *webSocketConnection) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(DefaultWebSocketWriteTimeout))
+		c.conn.Close()
+	})
+}
+
+// cleanup removes all subscriptions of the client. It waits for the
+// writer, because the connection is reused once serve returns.
+func (c *webSocketConnection) cleanup() {
+	c.close(websocket.CloseNormalClosure, "")
+	<-c.written
+
+	c.mutex.Lock()
+	for pattern, subscription := range c.subscriptions {
+		subscription.Unsubscribe()
+		delete(c.subscriptions, pattern)
+	}
+	c.mutex.Unlock()
+
+	c.logger.Info().Msg("WebSocket disconnected")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+func TestWebSocketReservedChannels(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+		err   *errs.ServiceError
+	}{
+		{name: "subscribe everything", frame: &Frame{Type: FrameSubscribe, Channel: ">"}, err: errs.InvalidCredentials},
+		{name: "subscribe wildcard first level", frame: &Frame{Type: FrameSubscribe, Channel: "*.>"}, err: errs.InvalidCredentials},
+		{name: "subscribe inboxes", frame: &Frame{Type: FrameSubscribe, Channel: "_INBOX.>"}, err: errs.InvalidCredentials},
+		{name: "publish to inbox", frame: &Frame{Type: FramePublish, Channel: "_INBOX.abc"}, err: errs.InvalidCredentials},
+		{name: "publish to system channel", frame: &Frame{Type: FramePublish, Channel: "channels.create"}, err: errs.InvalidCredentials},
+		{name: "request to system channel", frame: &Frame{Type: FrameRequest, Channel: "channels.delete"}, err: errs.InvalidCredentials},
+		{name: "publish to pattern", frame: &Frame{Type: FramePublish, Channel: "v1.pets.*"}, err: errs.InvalidEndpoint},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a policy, all other channels may be accessed.
+			logger := zerolog.Nop()
+			c := &webSocketConnection{
+				logger:        &logger,
+				caller:        &caller{subject: "alice"},
+				subscriptions: make(map[string]service.Subscription),
+				outgoing:      make(chan *Frame, 1),
+				done:          make(chan bool),
+				mutex:         &sync.Mutex{},
+			}
+			tt.frame.ID = "1"
+
+			c.handle(tt.frame)
+
+			frame := <-c.outgoing
+			if frame.Type != FrameError || frame.ID != "1" || frame.Error != tt.err {
+				t.Fatalf("expected error %v, got frame %+v", tt.err, frame)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+var (
+	name    = "unknown"
+	version = "dev"
+)
+
+// Config contains the configuration of the gateway.
+type Config struct {
+	config.Service
+	config.Broker
+	config.Auth
+
+	GatewayURI string `env:"GATEWAY_URI" required:"true"`
+	// GatewayTopicPrefix is the topic level below which requests are received.
+	GatewayTopicPrefix string `env:"GATEWAY_TOPIC_PREFIX" default:"requests"`
+	// GatewayTopics is a comma-separated list of the bridged
+	// topics, which are relative to the topic prefix.
+	GatewayTopics []string `env:"GATEWAY_TOPICS"`
+}
+
+func main() {
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
+
+	// Create new service instance.
+	svc := service.New(cfg.ServiceConfig(name, version))
+
+	// Load the credentials, the token validation, the policy and the API
+	// keys. If no policy is configured, all authenticated users may access
+	// all channels.
+	authenticator, policy, err := cfg.Auth.Load()
+	if err != nil {
+		svc.Logger.Fatal().Err(err).Msg("Failed to load authentication")
+	}
+
+	// Configure broker connection.
+	svc.UseBroker(broker.NewNATS(cfg.NATSOptions()))
+
+	// Configure gateway, which bridges the topics of an MQTT server.
+	var topics gateway.Option
+	if len(cfg.GatewayTopics) > 0 {
+		topics = gateway.Topics(cfg.GatewayTopics...)
+	}
+	svc.UseGateway(gateway.NewMQTT(
+		gateway.URI(cfg.GatewayURI),
+		gateway.TopicPrefix(cfg.GatewayTopicPrefix),
+		topics,
+	))
+
+	svc.GatewayMiddleware(NormalizeProtoToChannel())
+	svc.GatewayMiddleware(AuthN(authenticator))
+	svc.GatewayMiddleware(AuthZ(policy, authenticator.APIKeys))
+	svc.GatewayMiddleware(DispatchToChannel())
+
+	// Wait until error occurs or signal is received.
+	svc.Start()
+}
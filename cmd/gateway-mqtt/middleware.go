@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/gateway"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+const (
+	LocalsChannel  = "channel"
+	LocalsIdentity = "identity"
+
+	PropertyAuthorization = "authorization"
+	PropertyAPIKey        = "x-api-key"
+)
+
+func NormalizeProtoToChannel() service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.MQTTContext)
+
+		// Convert MQTT topic below the prefix to canonical channel.
+		ctx.Locals(LocalsChannel, ctx.Channel())
+
+		return ctx.Next()
+	}
+}
+
+// AuthN authenticates the request via the user properties of the
+// message, which carry the same credentials as the HTTP headers.
+func AuthN(authenticator *auth.Authenticator) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.MQTTContext)
+
+		identity, err := authenticator.Authenticate(ctx.Get(PropertyAuthorization), ctx.Get(PropertyAPIKey))
+		if err == auth.ErrMissingCredentials {
+			return errs.MissingCredentials
+		}
+		if err != nil {
+			return errs.InvalidCredentials
+		}
+
+		// Forward the caller to the backend services and
+		// log its requests with the channel and its subject.
+		ctx.Locals(LocalsIdentity, identity)
+		logger := r.Logger().With().
+			Str(service.LogFieldChannel, ctx.Locals(LocalsChannel).(string)).
+			Str(service.LogFieldUser, identity.Subject).
+			Logger()
+		ctx.SetUserContext(service.WithLogger(identity.WithContext(ctx.UserContext()), &logger))
+
+		return ctx.Next()
+	}
+}
+
+// AuthZ authorizes the caller to access the channel and counts the
+// request against the quota of API keys.
+func AuthZ(policy *auth.Policy, apiKeys *auth.APIKeys) service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.MQTTContext)
+		identity := ctx.Locals(LocalsIdentity).(*auth.Identity)
+
+		if err := gateway.Authorize(r.Logger(), policy, identity, ctx.Locals(LocalsChannel).(string)); err != nil {
+			return err
+		}
+
+		if _, err := gateway.ConsumeQuota(apiKeys, identity); err != nil {
+			return err
+		}
+
+		return ctx.Next()
+	}
+}
+
+// DispatchToChannel sends a request if the client expects a response.
+// Otherwise, the payload is published as event on the channel.
+func DispatchToChannel() service.RequestHandler {
+	return func(r *service.Request) error {
+		ctx := r.Context.(*gateway.MQTTContext)
+		channel := ctx.Locals(LocalsChannel).(string)
+
+		// Parse payload.
+		var body interface{}
+		if len(ctx.Payload()) > 0 {
+			if err := json.Unmarshal(ctx.Payload(), &body); err != nil {
+				return errs.InvalidJSON
+			}
+		}
+
+		if ctx.ResponseTopic() == "" {
+			if err := r.Service.Broker.PublishContext(ctx.UserContext(), channel, body); err != nil {
+				return errs.InvalidService
+			}
+			return nil
+		}
+
+		// Abort the request once the deadline of the gateway passed.
+		res, err := r.Service.Broker.RequestContext(ctx.UserContext(), channel, body)
+		if err != nil {
+			return errs.InvalidService
+		}
+
+		// Pass errors of the service to the error handler of the gateway.
+		if err := res.Err(); err != nil {
+			return err
+		}
+
+		// Use the status of the service or derive it from the channel.
+		return ctx.Respond(gateway.ResponseStatus(channel, res.Cloudevent), res.Cloudevent.Data())
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
-	"errors"
-	"os"
+	"context"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
 	"github.com/nicklasfrahm/showcases/pkg/mail"
 	"github.com/nicklasfrahm/showcases/pkg/service"
 )
@@ -16,47 +19,71 @@ var (
 )
 
 var (
-	ErrAllProvidersUnavailable = errors.New("mail: all providers unavailable")
+	ErrAllProvidersUnavailable = errs.NewServiceError(503, "All Providers Unavailable")
 )
 
+// Config contains the configuration of the service.
+type Config struct {
+	config.Service
+	config.Broker
+
+	MailFrom string `env:"MAIL_FROM" required:"true"`
+	// MailTimeout limits how long a provider may take to send a mail.
+	MailTimeout time.Duration `env:"MAIL_TIMEOUT" default:"1s"`
+
+	SendgridAPIKey   string `env:"SENDGRID_API_KEY" secret:"true"`
+	SendgridHTTPURI  string `env:"SENDGRID_HTTP_URI"`
+	SparkpostAPIKey  string `env:"SPARKPOST_API_KEY" secret:"true"`
+	SparkpostHTTPURI string `env:"SPARKPOST_HTTP_URI"`
+}
+
 func main() {
-	// Create new service instance.
-	svc := service.New(service.Config{
-		Name:    name,
-		Version: version,
-	})
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
 
-	// Fetch credentials from environment.
-	mailFrom := os.Getenv("MAIL_FROM")
-	sparkpostAPIKey := os.Getenv("SPARKPOST_API_KEY")
-	sparkpostHTTPURI := os.Getenv("SPARKPOST_HTTP_URI")
-	sendgridAPIKey := os.Getenv("SENDGRID_API_KEY")
-	sendgridHTTPURI := os.Getenv("SENDGRID_HTTP_URI")
+	// Create new service instance.
+	svc := service.New(cfg.ServiceConfig(name, version))
 
 	// Configure email providers.
 	mailers := make(map[string]mail.Mailer)
 
 	// Set up mailers.
 	mailers["sendgrid-http"] = mail.NewSendgridHTTP(&mail.Config{
-		URI:     sendgridHTTPURI,
-		APIKey:  sendgridAPIKey,
+		URI:     cfg.SendgridHTTPURI,
+		APIKey:  cfg.SendgridAPIKey,
 		Logger:  svc.Logger,
-		From:    mailFrom,
-		Timeout: 1 * time.Second,
+		From:    cfg.MailFrom,
+		Timeout: cfg.MailTimeout,
 	})
 	mailers["sparkpost-http"] = mail.NewSparkpostHTTP(&mail.Config{
-		URI:     sparkpostHTTPURI,
-		APIKey:  sparkpostAPIKey,
+		URI:     cfg.SparkpostHTTPURI,
+		APIKey:  cfg.SparkpostAPIKey,
 		Logger:  svc.Logger,
-		From:    mailFrom,
-		Timeout: 1 * time.Second,
+		From:    cfg.MailFrom,
+		Timeout: cfg.MailTimeout,
+	})
+
+	// Record the attempts to send mails per provider.
+	mailSends := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: service.MetricsNamespace,
+		Name:      "mail_sends_total",
+		Help:      "Number of attempts to send a mail by provider and result.",
+	}, []string{"provider", "result"})
+	svc.Metrics.Registry.MustRegister(mailSends)
+
+	// The service is not ready if all providers are disabled.
+	svc.HealthCheck("mail", func(ctx context.Context) error {
+		for _, mailer := range mailers {
+			if !mailer.MailProvider().Disabled {
+				return nil
+			}
+		}
+		return ErrAllProvidersUnavailable
 	})
 
 	// Configure broker connection.
-	svc.UseBroker(broker.NewNATS(&broker.NATSOptions{
-		URI:            os.Getenv("BROKER_URI"),
-		RequestTimeout: 20 * time.Millisecond,
-	}))
+	svc.UseBroker(broker.NewNATS(cfg.NATSOptions()))
 
 	svc.BrokerChannel("services.mail.providers.find", func(ctx *service.Context) error {
 		// Fetch information about mail providers.
@@ -68,55 +95,58 @@ func main() {
 			i += 1
 		}
 
-		// Send reply. Please note that the source is an opaque string
-		// that is used by the broker implementation to perform routing.
-		if err := ctx.Service.Broker.Publish(ctx.Cloudevent.Source(), mailProviders); err != nil {
+		// Send reply.
+		if err := ctx.Reply(mailProviders); err != nil {
 			return err
 		}
 		// Broadcast event.
 		return ctx.Service.Broker.Publish("v1.services.mail.providers.found", mailProviders)
 	})
 
-	svc.BrokerChannel("mails.create", func(ctx *service.Context) error {
+	// Mails are persisted, such that they are not lost if no instance is running.
+	svc.PersistentBrokerChannel(service.PersistentChannel{
+		Name:       "mails.create",
+		MaxDeliver: 5,
+		AckWait:    30 * time.Second,
+	}, func(ctx *service.Context) error {
 		// Parse cloudevent and marshal it into a struct.
 		mail := new(mail.Mail)
 		if err := ctx.Cloudevent.DataAs(mail); err != nil {
-			// TODO: Improve error handling by sending appropriate error code
-			// to gateway like as 400 or 422, because the validation failed.
-			// This will just silently fail and cause the service to return
-			// error code 503, which is not very descriptive.
-			return nil
+			// The error is replied to the gateway and the mail is not redelivered.
+			return errs.InvalidData
 		}
 
-		for _, mailer := range mailers {
+		for provider, mailer := range mailers {
 			// Check if provider is disabled.
 			if !mailer.MailProvider().Disabled {
 				// Attempt to send email.
 				err := mailer.Send(mail)
 				if err == nil {
 					// Sucessfully sent email. Don't retry.
+					mailSends.WithLabelValues(provider, service.ResultSuccess).Inc()
 					break
 				}
+				mailSends.WithLabelValues(provider, service.ResultError).Inc()
 
 				// Display warning message upon failed delivery attempt.
-				ctx.Service.Logger.Warn().Err(err).Msgf("Failed to send mail")
+				ctx.Logger().Warn().Err(err).Msgf("Failed to send mail")
 			}
 		}
 
 		// If the mail provider was set, the email was sent sucessfully.
 		if mail.MailProvider != nil {
-			if err := ctx.Service.Broker.Publish(ctx.Cloudevent.Source(), mail); err != nil {
-				return err
+			// Do not reject the mail if the reply fails, as it would be sent again.
+			if err := ctx.Reply(mail); err != nil {
+				ctx.Logger().Warn().Err(err).Msg("Failed to reply")
 			}
+			return nil
 		}
 
-		// Broadcast unsent email.
-		if err := ctx.Service.Broker.Publish("mails.unsent", mail); err != nil {
-			return err
-		}
-
+		// Reject the mail, which causes it to be redelivered. The
+		// failure is broadcast on the `mails.create.failure` channel.
 		return ErrAllProvidersUnavailable
 	})
+	svc.Broadcast("mails.create", true)
 
 	// Wait until error occurs or signal is received.
 	svc.Start()
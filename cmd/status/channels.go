@@ -24,9 +24,8 @@ func ChannelsFind(ctx *service.Context) error {
 	// Release lock.
 	mutex.Unlock()
 
-	// Send reply. Please note that the source is an opaque string
-	// that is used by the broker implementation to perform routing.
-	if err := ctx.Service.Broker.Publish(ctx.Cloudevent.Source(), channelList); err != nil {
+	// Send reply.
+	if err := ctx.Reply(channelList); err != nil {
 		return err
 	}
 	// Broadcast event.
@@ -52,9 +51,8 @@ func ChannelsCreate(ctx *service.Context) error {
 	// Release lock.
 	mutex.Unlock()
 
-	// Send reply. Please note that the source is an opaque string
-	// that is used by the broker implementation to perform routing.
-	if err := ctx.Service.Broker.Publish(ctx.Cloudevent.Source(), channel); err != nil {
+	// Send reply.
+	if err := ctx.Reply(channel); err != nil {
 		return err
 	}
 	// Broadcast event.
@@ -84,9 +82,8 @@ func ChannelsDelete(ctx *service.Context) error {
 	// Release lock.
 	mutex.Unlock()
 
-	// Send reply. Please note that the source is an opaque string
-	// that is used by the broker implementation to perform routing.
-	if err := ctx.Service.Broker.Publish(ctx.Cloudevent.Source(), channel); err != nil {
+	// Send reply.
+	if err := ctx.Reply(channel); err != nil {
 		return err
 	}
 	// Broadcast event.
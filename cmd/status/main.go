@@ -1,10 +1,8 @@
 package main
 
 import (
-	"os"
-	"time"
-
 	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/config"
 	"github.com/nicklasfrahm/showcases/pkg/service"
 )
 
@@ -13,18 +11,22 @@ var (
 	version = "dev"
 )
 
+// Config contains the configuration of the service.
+type Config struct {
+	config.Service
+	config.Broker
+}
+
 func main() {
+	// Load configuration.
+	cfg := new(Config)
+	config.MustLoad(cfg)
+
 	// Create new service instance.
-	svc := service.New(service.Config{
-		Name:    name,
-		Version: version,
-	})
+	svc := service.New(cfg.ServiceConfig(name, version))
 
 	// Configure broker connection.
-	svc.UseBroker(broker.NewNATS(&broker.NATSOptions{
-		URI:            os.Getenv("BROKER_URI"),
-		RequestTimeout: 20 * time.Millisecond,
-	}))
+	svc.UseBroker(broker.NewNATS(cfg.NATSOptions()))
 
 	svc.BrokerChannel("channels.create", ChannelsCreate)
 	svc.BrokerChannel("channels.find", ChannelsFind)
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains a store for API keys. Keys are stored as hex-encoded
+// SHA-256 hashes, which is sufficient for randomly generated keys. Every
+// key may only access the listed channel patterns and is limited to a
+// quota of requests per time window, for example:
+//
+//   {
+//     "keys": [
+//       {
+//         "id": "ci",
+//         "hash": "<sha256 of the key>",
+//         "channels": ["v1.mails.create"],
+//         "quota": 1000,
+//         "window": "1h"
+//       }
+//     ]
+//   }
+//
+// A quota of zero means that the key is not limited.
+
+var (
+	ErrInvalidAPIKey  = errors.New("auth: api key invalid")
+	ErrQuotaExceeded  = errors.New("auth: api key quota exceeded")
+	ErrAPIKeysInvalid = errors.New("auth: api keys malformed")
+)
+
+// APIKey describes an API key and its permissions.
+type APIKey struct {
+	ID       string   `json:"id"`
+	Hash     string   `json:"hash"`
+	Channels []string `json:"channels"`
+	Quota    int      `json:"quota"`
+	Window   string   `json:"window"`
+	Disabled bool     `json:"disabled"`
+
+	window      time.Duration
+	requests    int
+	windowStart time.Time
+}
+
+// APIKeyUsage contains the usage of an API key in the current window.
+type APIKeyUsage struct {
+	ID        string    `json:"id"`
+	Requests  int       `json:"requests"`
+	Quota     int       `json:"quota"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// APIKeys authenticates API keys and counts their usage.
+type APIKeys struct {
+	keys  map[string]*APIKey
+	mutex *sync.Mutex
+}
+
+// LoadAPIKeys reads and validates a file of API keys.
+func LoadAPIKeys(file string) (*APIKeys, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile := struct {
+		Keys []*APIKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(raw, &keyFile); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrAPIKeysInvalid, err)
+	}
+
+	apiKeys := &APIKeys{
+		keys:  make(map[string]*APIKey),
+		mutex: &sync.Mutex{},
+	}
+	for i, key := range keyFile.Keys {
+		if key.ID == "" || len(key.Hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("%w: key %d has no id or invalid hash", ErrAPIKeysInvalid, i)
+		}
+
+		if key.Quota > 0 {
+			if key.window, err = time.ParseDuration(key.Window); err != nil || key.window <= 0 {
+				return nil, fmt.Errorf("%w: key %s has invalid window", ErrAPIKeysInvalid, key.ID)
+			}
+		}
+
+		apiKeys.keys[strings.ToLower(key.Hash)] = key
+	}
+
+	return apiKeys, nil
+}
+
+// Authenticate returns the API key that matches the secret.
+func (apiKeys *APIKeys) Authenticate(secret string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(secret))
+
+	apiKeys.mutex.Lock()
+	key := apiKeys.keys[hex.EncodeToString(hash[:])]
+	apiKeys.mutex.Unlock()
+
+	if key == nil || key.Disabled {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return key, nil
+}
+
+// Allowed checks if the API key may access the channel.
+func (key *APIKey) Allowed(channel string) bool {
+	for _, pattern := range key.Channels {
+		if broker.MatchChannel(pattern, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedPattern checks if the API key may access all channels that
+// match the pattern, which may contain wildcards.
+func (key *APIKey) AllowedPattern(pattern string) bool {
+	for _, allowed := range key.Channels {
+		if broker.CoversPattern(allowed, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Consume counts a request of the API key and returns its usage. It
+// returns an error if the quota of the current window is exhausted.
+func (apiKeys *APIKeys) Consume(key *APIKey) (APIKeyUsage, error) {
+	apiKeys.mutex.Lock()
+	defer apiKeys.mutex.Unlock()
+
+	if key.Quota > 0 && key.requests >= key.Quota && time.Since(key.windowStart) < key.window {
+		return key.usage(), ErrQuotaExceeded
+	}
+
+	key.reset()
+	key.requests += 1
+
+	return key.usage(), nil
+}
+
+// Usage returns the usage of all API keys.
+func (apiKeys *APIKeys) Usage() []APIKeyUsage {
+	apiKeys.mutex.Lock()
+	defer apiKeys.mutex.Unlock()
+
+	usage := make([]APIKeyUsage, 0, len(apiKeys.keys))
+	for _, key := range apiKeys.keys {
+		key.reset()
+		usage = append(usage, key.usage())
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].ID < usage[j].ID
+	})
+
+	return usage
+}
+
+// reset starts a new window if the current one has passed. The mutex must be held.
+func (key *APIKey) reset() {
+	if time.Since(key.windowStart) >= key.window {
+		key.windowStart = time.Now()
+		key.requests = 0
+	}
+}
+
+// usage returns the usage of the current window. The mutex must be held.
+func (key *APIKey) usage() APIKeyUsage {
+	usage := APIKeyUsage{
+		ID:       key.ID,
+		Requests: key.requests,
+		Quota:    key.Quota,
+	}
+
+	if key.Quota > 0 {
+		usage.Remaining = key.Quota - key.requests
+		usage.Reset = key.windowStart.Add(key.window)
+	}
+
+	return usage
+}
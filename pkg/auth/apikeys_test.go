@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestAPIKeyAllowed(t *testing.T) {
+	key := &APIKey{ID: "ci", Channels: []string{"v1.pets.read", "v1.mails.*", "v1.status.>"}}
+
+	tests := []struct {
+		name    string
+		channel string
+		allowed bool
+	}{
+		{name: "concrete channel", channel: "v1.pets.read", allowed: true},
+		{name: "other action", channel: "v1.pets.delete"},
+		{name: "single-level wildcard", channel: "v1.mails.create", allowed: true},
+		{name: "single-level wildcard too deep", channel: "v1.mails.providers.find"},
+		{name: "multi-level wildcard", channel: "v1.status.services.find", allowed: true},
+		{name: "multi-level wildcard without level", channel: "v1.status"},
+		{name: "other channel", channel: "v2.pets.read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := key.Allowed(tt.channel); allowed != tt.allowed {
+				t.Errorf("expected %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAllowedPattern(t *testing.T) {
+	key := &APIKey{ID: "ci", Channels: []string{"v1.pets.read", "v1.mails.*", "v1.status.>"}}
+
+	tests := []struct {
+		name    string
+		pattern string
+		allowed bool
+	}{
+		{name: "concrete channel", pattern: "v1.pets.read", allowed: true},
+		{name: "wider than concrete channel", pattern: "v1.pets.*"},
+		{name: "same single-level wildcard", pattern: "v1.mails.*", allowed: true},
+		{name: "multi-level wildcard of single-level wildcard", pattern: "v1.mails.>"},
+		{name: "narrower multi-level wildcard", pattern: "v1.status.services.>", allowed: true},
+		{name: "single-level wildcard of multi-level wildcard", pattern: "v1.status.*", allowed: true},
+		{name: "same multi-level wildcard", pattern: "v1.status.>", allowed: true},
+		{name: "wider multi-level wildcard", pattern: "v1.>"},
+		{name: "everything", pattern: ">"},
+		{name: "wildcard resource", pattern: "v1.*.read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := key.AllowedPattern(tt.pattern); allowed != tt.allowed {
+				t.Errorf("expected %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the authentication that is shared by all gateways.
+// Callers authenticate via an API key or via the value of an HTTP-style
+// authorization header using the `Basic` or `Bearer` scheme, regardless
+// of the protocol that transports it.
+
+var (
+	ErrMissingCredentials = errors.New("auth: credentials missing")
+)
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	Subject string
+	Roles   []string
+	// Claims are only set if the caller authenticated via a token.
+	Claims Claims
+	// APIKey is only set if the caller authenticated via an API key.
+	APIKey *APIKey
+}
+
+// Authenticator verifies the credentials of callers. Methods
+// of authentication that are not configured are rejected.
+type Authenticator struct {
+	Credentials CredentialStore
+	JWT         *JWT
+	APIKeys     *APIKeys
+}
+
+// Authenticate authenticates the caller. API keys are preferred over the
+// authorization, which must use either the `Basic` or `Bearer` scheme.
+func (a *Authenticator) Authenticate(authorization string, apiKey string) (*Identity, error) {
+	if apiKey != "" {
+		if a.APIKeys == nil {
+			return nil, ErrInvalidAPIKey
+		}
+
+		key, err := a.APIKeys.Authenticate(apiKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Identity{Subject: key.ID, APIKey: key}, nil
+	}
+
+	// Check if credentials are present with the right authentication scheme.
+	segments := strings.Split(authorization, " ")
+	if len(segments) != 2 {
+		return nil, ErrMissingCredentials
+	}
+
+	switch strings.ToLower(segments[0]) {
+	case "bearer":
+		return a.authenticateBearer(segments[1])
+	case "basic":
+		return a.authenticateBasic(segments[1])
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// authenticateBearer validates the token.
+func (a *Authenticator) authenticateBearer(token string) (*Identity, error) {
+	if a.JWT == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	claims, err := a.JWT.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{Subject: claims.Subject(), Roles: claims.Roles(), Claims: claims}, nil
+}
+
+// authenticateBasic decodes and verifies the user and password.
+func (a *Authenticator) authenticateBasic(encoded string) (*Identity, error) {
+	if a.Credentials == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrCredentialsMalformed
+	}
+
+	userPass := strings.SplitN(string(decoded), ":", 2)
+	if len(userPass) != 2 {
+		return nil, ErrCredentialsMalformed
+	}
+
+	if err := a.Credentials.Verify(userPass[0], userPass[1]); err != nil {
+		return nil, err
+	}
+
+	return &Identity{Subject: userPass[0]}, nil
+}
+
+// Allowed checks if the caller may access the channel. API keys are
+// authorized by their own channels instead of the policy. If no policy
+// is configured, all authenticated callers may access all channels.
+func (identity *Identity) Allowed(policy *Policy, channel string) bool {
+	switch {
+	case identity.APIKey != nil:
+		return identity.APIKey.Allowed(channel)
+	case policy != nil:
+		return policy.Allowed(identity.Subject, identity.Roles, channel)
+	}
+
+	return true
+}
+
+// AllowedPattern checks if the caller may access all channels that
+// match the pattern, such as the pattern of a subscription.
+func (identity *Identity) AllowedPattern(policy *Policy, pattern string) bool {
+	switch {
+	case identity.APIKey != nil:
+		return identity.APIKey.AllowedPattern(pattern)
+	case policy != nil:
+		return policy.AllowedPattern(identity.Subject, identity.Roles, pattern)
+	}
+
+	return true
+}
+
+// WithContext returns a copy of the context, which forwards the
+// caller to the backend services via cloud event extensions.
+func (identity *Identity) WithContext(ctx context.Context) context.Context {
+	ctx = service.WithExtension(ctx, service.ExtensionAuthSubject, identity.Subject)
+	if identity.Claims != nil {
+		if encoded, err := json.Marshal(identity.Claims); err == nil {
+			ctx = service.WithExtension(ctx, service.ExtensionAuthClaims, string(encoded))
+		}
+	}
+
+	return ctx
+}
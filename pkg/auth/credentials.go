@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// This file contains a credential store in a format similar to htpasswd.
+// Every line contains a user and a password hash separated by a colon.
+// Users can be disabled by appending the flag `disabled`, for example:
+//
+//   # Comments and empty lines are ignored.
+//   alice:$2y$10$...
+//   bob:$argon2id$v=19$m=65536,t=3,p=4$...$...:disabled
+//
+// Supported hashes are bcrypt (`$2a$`, `$2b$`, `$2y$`) and argon2 in
+// the PHC string format (`$argon2id$`, `$argon2i$`).
+
+const (
+	DefaultCredentialsReloadInterval = 5 * time.Second
+
+	credentialFlagDisabled = "disabled"
+)
+
+var (
+	ErrInvalidCredentials   = errors.New("auth: credentials invalid")
+	ErrUserDisabled         = errors.New("auth: user disabled")
+	ErrCredentialsMalformed = errors.New("auth: credentials malformed")
+)
+
+// CredentialStore verifies the credentials of users.
+type CredentialStore interface {
+	Verify(user string, password string) error
+}
+
+type CredentialsOptions struct {
+	// File is the path of the credential file. It is reloaded
+	// whenever it changes.
+	File string
+	// Data contains the credentials line by line if no file is configured.
+	Data string
+	// ReloadInterval limits how often the file is checked for changes.
+	ReloadInterval time.Duration
+}
+
+type credential struct {
+	hash     string
+	disabled bool
+}
+
+// Credentials is a credential store that is loaded from a file or a string.
+type Credentials struct {
+	options     *CredentialsOptions
+	credentials map[string]*credential
+	checkedAt   time.Time
+	modTime     time.Time
+	size        int64
+	mutex       *sync.RWMutex
+}
+
+var (
+	// dummyHash is compared for unknown users to prevent
+	// attackers from detecting existing users via timing.
+	dummyHash     []byte
+	dummyHashOnce = &sync.Once{}
+)
+
+// NewCredentials creates a new credential store. It returns an
+// error if the credentials can not be loaded.
+func NewCredentials(opts *CredentialsOptions) (*Credentials, error) {
+	if opts.ReloadInterval == 0 {
+		opts.ReloadInterval = DefaultCredentialsReloadInterval
+	}
+
+	store := &Credentials{
+		options:     opts,
+		credentials: make(map[string]*credential),
+		mutex:       &sync.RWMutex{},
+	}
+
+	if opts.File == "" {
+		credentials, err := parseCredentials(strings.NewReader(opts.Data))
+		if err != nil {
+			return nil, err
+		}
+		store.credentials = credentials
+
+		return store, nil
+	}
+
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Verify checks the password of the user in constant time.
+func (store *Credentials) Verify(user string, password string) error {
+	store.refresh()
+
+	store.mutex.RLock()
+	cred := store.credentials[user]
+	store.mutex.RUnlock()
+
+	if cred == nil {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ErrInvalidCredentials
+	}
+
+	if !compareHash(cred.hash, password) {
+		return ErrInvalidCredentials
+	}
+
+	// Only reveal that the user is disabled if the password is valid.
+	if cred.disabled {
+		return ErrUserDisabled
+	}
+
+	return nil
+}
+
+// refresh reloads the file if it changed since it was last loaded.
+func (store *Credentials) refresh() {
+	if store.options.File == "" {
+		return
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if time.Since(store.checkedAt) < store.options.ReloadInterval {
+		return
+	}
+	store.checkedAt = time.Now()
+
+	info, err := os.Stat(store.options.File)
+	if err != nil || (info.ModTime().Equal(store.modTime) && info.Size() == store.size) {
+		return
+	}
+
+	// Keep the previous credentials if the file is invalid.
+	store.load()
+}
+
+// reload loads the file while holding the mutex.
+func (store *Credentials) reload() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.checkedAt = time.Now()
+
+	return store.load()
+}
+
+// load parses the file. The mutex must be held.
+func (store *Credentials) load() error {
+	file, err := os.Open(store.options.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	credentials, err := parseCredentials(file)
+	if err != nil {
+		return err
+	}
+
+	store.credentials = credentials
+	store.modTime = info.ModTime()
+	store.size = info.Size()
+
+	return nil
+}
+
+// parseCredentials parses the credentials line by line.
+func parseCredentials(r io.Reader) (map[string]*credential, error) {
+	credentials := make(map[string]*credential)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("%w: line %d", ErrCredentialsMalformed, line)
+		}
+
+		// Reject plaintext passwords instead of never matching them.
+		if !supportedHash(fields[1]) {
+			return nil, fmt.Errorf("%w: line %d: unsupported hash", ErrCredentialsMalformed, line)
+		}
+
+		cred := &credential{hash: fields[1]}
+		if len(fields) == 3 {
+			if fields[2] != credentialFlagDisabled {
+				return nil, fmt.Errorf("%w: line %d", ErrCredentialsMalformed, line)
+			}
+			cred.disabled = true
+		}
+		credentials[fields[0]] = cred
+	}
+
+	return credentials, scanner.Err()
+}
+
+// supportedHash checks if the hash is a bcrypt or argon2 hash.
+func supportedHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2") || strings.HasPrefix(hash, "$argon2")
+}
+
+// compareHash checks if the password matches the hash.
+func compareHash(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2"):
+		return compareArgon2(hash, password)
+	}
+
+	return false
+}
+
+// compareArgon2 checks the password against an argon2 hash in the PHC
+// string format: `$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>`.
+func compareArgon2(hash string, password string) bool {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil {
+		return false
+	}
+
+	var actual []byte
+	switch fields[1] {
+	case "argon2id":
+		actual = argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	case "argon2i":
+		actual = argon2.Key([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
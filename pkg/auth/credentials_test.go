@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// argon2Hash creates an argon2 hash in the PHC string format.
+func argon2Hash(variant string, password string) string {
+	salt := []byte("saltsaltsaltsalt")
+
+	var key []byte
+	switch variant {
+	case "argon2id":
+		key = argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	case "argon2i":
+		key = argon2.Key([]byte(password), salt, 1, 64, 1, 32)
+	}
+
+	return fmt.Sprintf("$%s$v=%d$m=64,t=1,p=1$%s$%s", variant, argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestCredentialsVerify(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := NewCredentials(&CredentialsOptions{
+		Data: strings.Join([]string{
+			"# Comments and empty lines are ignored.",
+			"",
+			"bcrypt:" + string(bcryptHash),
+			"argon2id:" + argon2Hash("argon2id", "argon2id-pw"),
+			"argon2i:" + argon2Hash("argon2i", "argon2i-pw"),
+			"disabled:" + string(bcryptHash) + ":disabled",
+			"version:" + strings.Replace(argon2Hash("argon2id", "version-pw"), "v=19", "v=16", 1),
+		}, "\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		err      error
+	}{
+		{name: "bcrypt", user: "bcrypt", password: "bcrypt-pw"},
+		{name: "bcrypt wrong password", user: "bcrypt", password: "wrong", err: ErrInvalidCredentials},
+		{name: "argon2id", user: "argon2id", password: "argon2id-pw"},
+		{name: "argon2id wrong password", user: "argon2id", password: "wrong", err: ErrInvalidCredentials},
+		{name: "argon2i", user: "argon2i", password: "argon2i-pw"},
+		{name: "argon2i wrong password", user: "argon2i", password: "wrong", err: ErrInvalidCredentials},
+		{name: "unknown user", user: "unknown", password: "bcrypt-pw", err: ErrInvalidCredentials},
+		{name: "disabled user", user: "disabled", password: "bcrypt-pw", err: ErrUserDisabled},
+		{name: "disabled user wrong password", user: "disabled", password: "wrong", err: ErrInvalidCredentials},
+		{name: "unsupported argon2 version", user: "version", password: "version-pw", err: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := credentials.Verify(tt.user, tt.password); err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNewCredentialsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "missing hash", data: "alice"},
+		{name: "empty hash", data: "alice:"},
+		{name: "empty user", data: ":$2y$10$hash"},
+		{name: "unknown flag", data: "alice:$2y$10$hash:locked"},
+		{name: "unsupported hash", data: "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g="},
+		{name: "plaintext password", data: "alice:password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCredentials(&CredentialsOptions{Data: tt.data}); !errors.Is(err, ErrCredentialsMalformed) {
+				t.Fatalf("expected error %v, got %v", ErrCredentialsMalformed, err)
+			}
+		})
+	}
+}
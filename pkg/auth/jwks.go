@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// This file contains a cache for JSON Web Key Sets (JWKS) as specified
+// in RFC 7517. The key set is reloaded periodically and whenever a token
+// references an unknown key, which allows the issuer to rotate its keys.
+// Reloads happen in the background and at most once per minimum refresh
+// interval, while the cached keys continue to be served.
+
+var (
+	ErrJWKSUnavailable = errors.New("auth: key set unavailable")
+)
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	// Parameters of RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+	// Parameters of elliptic curve keys.
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jwks struct {
+	url             string
+	file            string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	keys        map[string]interface{}
+	refreshedAt time.Time
+	attemptedAt time.Time
+	// refreshing is closed once the running refresh completed.
+	refreshing chan bool
+	mutex      *sync.Mutex
+}
+
+func newJWKS(url string, file string, refreshInterval time.Duration) *jwks {
+	return &jwks{
+		url:             url,
+		file:            file,
+		refreshInterval: refreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		keys:            make(map[string]interface{}),
+		mutex:           &sync.Mutex{},
+	}
+}
+
+// find returns the public key with the given key ID. If the token does
+// not reference a key ID, the key set must contain exactly one key.
+func (set *jwks) find(keyID string) interface{} {
+	if set.url == "" && set.file == "" {
+		return nil
+	}
+
+	set.mutex.Lock()
+	now := time.Now()
+	stale := now.Sub(set.refreshedAt) > set.refreshInterval
+	known := set.lookup(keyID) != nil
+
+	// Refresh at a limited rate to prevent tokens with random key IDs
+	// or an unavailable key set from flooding the issuer.
+	if (stale || !known) && set.refreshing == nil && now.Sub(set.attemptedAt) > DefaultJWKSMinRefreshInterval {
+		set.attemptedAt = now
+		set.refreshing = make(chan bool)
+		go set.refresh()
+	}
+	refreshing := set.refreshing
+	set.mutex.Unlock()
+
+	// Cached keys are used while the key set is refreshed,
+	// so only unknown keys have to wait for the refresh.
+	if !known && refreshing != nil {
+		<-refreshing
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	return set.lookup(keyID)
+}
+
+// lookup returns the cached key with the given key ID. The mutex must be held.
+func (set *jwks) lookup(keyID string) interface{} {
+	if keyID == "" && len(set.keys) == 1 {
+		for _, key := range set.keys {
+			return key
+		}
+	}
+
+	return set.keys[keyID]
+}
+
+// refresh loads the key set without holding the mutex, such that
+// requests are not blocked by a slow issuer, and replaces the cached
+// keys. The cached keys are kept if the key set is unavailable.
+func (set *jwks) refresh() {
+	keys, err := set.load()
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	if err == nil {
+		set.keys = keys
+		set.refreshedAt = time.Now()
+	}
+	close(set.refreshing)
+	set.refreshing = nil
+}
+
+// load reads and parses the key set.
+func (set *jwks) load() (map[string]interface{}, error) {
+	var raw []byte
+	var err error
+	if set.url != "" {
+		raw, err = set.fetch()
+	} else {
+		raw, err = os.ReadFile(set.file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := new(jsonWebKeySet)
+	if err := json.Unmarshal(raw, keySet); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range keySet.Keys {
+		// Ignore keys that are used for encryption.
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+// fetch downloads the key set from the URL.
+func (set *jwks) fetch() ([]byte, error) {
+	res, err := set.client.Get(set.url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrJWKSUnavailable, res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+// publicKey converts the JSON Web Key into an RSA or ECDSA public key.
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		// ES256 requires the P-256 curve.
+		if jwk.Curve != "P-256" {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return key, nil
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer.
+func decodeBigInt(encoded string) (*big.Int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(decoded), nil
+}
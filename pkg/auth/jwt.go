@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// This file contains the validation of JSON Web Tokens (JWT) as
+// specified in RFC 7519. Only the algorithms HS256, RS256 and ES256
+// are supported. The algorithm must match the type of the key to
+// prevent attackers from choosing the algorithm.
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+
+	DefaultJWTLeeway           = 1 * time.Minute
+	DefaultJWKSRefreshInterval = 1 * time.Hour
+	// DefaultJWKSMinRefreshInterval limits how often unknown key IDs
+	// and stale or unavailable key sets may trigger a refresh.
+	DefaultJWKSMinRefreshInterval = 1 * time.Minute
+)
+
+var (
+	ErrTokenMalformed       = errors.New("auth: token malformed")
+	ErrTokenSignature       = errors.New("auth: token signature invalid")
+	ErrTokenExpired         = errors.New("auth: token expired")
+	ErrTokenNotYetValid     = errors.New("auth: token not yet valid")
+	ErrTokenIssuer          = errors.New("auth: token issuer invalid")
+	ErrTokenAudience        = errors.New("auth: token audience invalid")
+	ErrUnsupportedAlgorithm = errors.New("auth: token algorithm unsupported")
+	ErrKeyNotFound          = errors.New("auth: token key not found")
+)
+
+type JWTOptions struct {
+	// Issuer is the expected `iss` claim. It is not checked if empty.
+	Issuer string
+	// Audience is the expected `aud` claim. It is not checked if empty.
+	Audience string
+	// Secret is the key of tokens signed via HS256.
+	Secret []byte
+	// JWKSURL and JWKSFile are the sources of the public keys
+	// of tokens signed via RS256 or ES256.
+	JWKSURL  string
+	JWKSFile string
+	// RefreshInterval is the maximum age of the cached key set.
+	RefreshInterval time.Duration
+	// Leeway is the tolerated clock skew when validating timestamps.
+	Leeway time.Duration
+}
+
+// Claims contains the claims of a validated token.
+type Claims map[string]interface{}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// JWT validates tokens and caches the key set used to verify them.
+type JWT struct {
+	options *JWTOptions
+	keys    *jwks
+}
+
+// NewJWT creates a new validator for JSON Web Tokens.
+func NewJWT(opts *JWTOptions) *JWT {
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = DefaultJWKSRefreshInterval
+	}
+
+	if opts.Leeway == 0 {
+		opts.Leeway = DefaultJWTLeeway
+	}
+
+	return &JWT{
+		options: opts,
+		keys:    newJWKS(opts.JWKSURL, opts.JWKSFile, opts.RefreshInterval),
+	}
+}
+
+// Verify validates the signature and the claims of the token.
+func (j *JWT) Verify(token string) (Claims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	header := new(jwtHeader)
+	if err := decodeSegment(segments[0], header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if err := j.verifySignature(header, segments[0]+"."+segments[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(Claims)
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	if err := j.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature with the key that matches the algorithm.
+func (j *JWT) verifySignature(header *jwtHeader, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch header.Algorithm {
+	case AlgorithmHS256:
+		if len(j.options.Secret) == 0 {
+			return ErrKeyNotFound
+		}
+		mac := hmac.New(sha256.New, j.options.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrTokenSignature
+		}
+	case AlgorithmRS256:
+		key, ok := j.keys.find(header.KeyID).(*rsa.PublicKey)
+		if !ok {
+			return ErrKeyNotFound
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrTokenSignature
+		}
+	case AlgorithmES256:
+		key, ok := j.keys.find(header.KeyID).(*ecdsa.PublicKey)
+		if !ok {
+			return ErrKeyNotFound
+		}
+		// The signature is the concatenation of R and S.
+		if len(signature) != 64 {
+			return ErrTokenSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return ErrTokenSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return nil
+}
+
+// verifyClaims checks the registered claims of the token.
+func (j *JWT) verifyClaims(claims Claims) error {
+	now := time.Now()
+
+	exp, ok := claims.time("exp")
+	if !ok {
+		return ErrTokenMalformed
+	}
+	if now.After(exp.Add(j.options.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	if nbf, ok := claims.time("nbf"); ok && now.Add(j.options.Leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	}
+
+	if j.options.Issuer != "" && claims.Issuer() != j.options.Issuer {
+		return ErrTokenIssuer
+	}
+
+	if j.options.Audience != "" && !claims.HasAudience(j.options.Audience) {
+		return ErrTokenAudience
+	}
+
+	return nil
+}
+
+// Subject returns the `sub` claim.
+func (c Claims) Subject() string {
+	subject, _ := c["sub"].(string)
+	return subject
+}
+
+// Issuer returns the `iss` claim.
+func (c Claims) Issuer() string {
+	issuer, _ := c["iss"].(string)
+	return issuer
+}
+
+// Roles returns the `roles` claim, which is a list of strings.
+func (c Claims) Roles() []string {
+	values, _ := c["roles"].([]interface{})
+
+	roles := make([]string, 0, len(values))
+	for _, value := range values {
+		if role, ok := value.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
+
+// HasAudience checks if the `aud` claim, which is either a
+// single string or a list of strings, contains the audience.
+func (c Claims) HasAudience(audience string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// time returns a claim that contains a numeric date.
+func (c Claims) time(name string) (time.Time, bool) {
+	seconds, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(seconds), 0), true
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a token.
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(decoded, v)
+}
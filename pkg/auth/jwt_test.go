@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signToken creates a token with the header and the claims. The key is
+// a secret for HS256 or a private key for RS256 and ES256.
+func signToken(t *testing.T, header map[string]interface{}, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// writeJWKS writes the public keys as key set and returns its path.
+func writeJWKS(t *testing.T, keys map[string]interface{}) string {
+	t.Helper()
+
+	keySet := jsonWebKeySet{}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			keySet.Keys = append(keySet.Keys, jsonWebKey{
+				KeyType: "RSA",
+				KeyID:   kid,
+				N:       base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			keySet.Keys = append(keySet.Keys, jsonWebKey{
+				KeyType: "EC",
+				KeyID:   kid,
+				Curve:   "P-256",
+				X:       base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+				Y:       base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+
+	encoded, err := json.Marshal(keySet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, encoded, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestJWTVerify(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator := NewJWT(&JWTOptions{
+		Issuer:   "issuer",
+		Audience: "gateway",
+		Secret:   secret,
+		JWKSFile: writeJWKS(t, map[string]interface{}{
+			"rsa": &rsaKey.PublicKey,
+			"ec":  &ecKey.PublicKey,
+		}),
+		Leeway: time.Minute,
+	})
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "alice",
+			"iss": "issuer",
+			"aud": "gateway",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+				continue
+			}
+			c[name] = value
+		}
+		return c
+	}
+	hs256 := map[string]interface{}{"alg": AlgorithmHS256}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{
+			name:  "HS256",
+			token: signToken(t, hs256, claims(nil), secret),
+		},
+		{
+			name:  "RS256",
+			token: signToken(t, map[string]interface{}{"alg": AlgorithmRS256, "kid": "rsa"}, claims(nil), rsaKey),
+		},
+		{
+			name:  "ES256",
+			token: signToken(t, map[string]interface{}{"alg": AlgorithmES256, "kid": "ec"}, claims(nil), ecKey),
+		},
+		{
+			name:  "audience list",
+			token: signToken(t, hs256, claims(map[string]interface{}{"aud": []string{"other", "gateway"}}), secret),
+		},
+		{
+			name:  "expired within leeway",
+			token: signToken(t, hs256, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()}), secret),
+		},
+		{
+			name:  "expired",
+			token: signToken(t, hs256, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), secret),
+			err:   ErrTokenExpired,
+		},
+		{
+			name:  "missing expiry",
+			token: signToken(t, hs256, claims(map[string]interface{}{"exp": nil}), secret),
+			err:   ErrTokenMalformed,
+		},
+		{
+			name:  "not yet valid within leeway",
+			token: signToken(t, hs256, claims(map[string]interface{}{"nbf": now.Add(30 * time.Second).Unix()}), secret),
+		},
+		{
+			name:  "not yet valid",
+			token: signToken(t, hs256, claims(map[string]interface{}{"nbf": now.Add(2 * time.Minute).Unix()}), secret),
+			err:   ErrTokenNotYetValid,
+		},
+		{
+			name:  "wrong issuer",
+			token: signToken(t, hs256, claims(map[string]interface{}{"iss": "other"}), secret),
+			err:   ErrTokenIssuer,
+		},
+		{
+			name:  "wrong audience",
+			token: signToken(t, hs256, claims(map[string]interface{}{"aud": "other"}), secret),
+			err:   ErrTokenAudience,
+		},
+		{
+			name:  "wrong secret",
+			token: signToken(t, hs256, claims(nil), []byte("other")),
+			err:   ErrTokenSignature,
+		},
+		{
+			name:  "wrong key",
+			token: signToken(t, map[string]interface{}{"alg": AlgorithmRS256, "kid": "rsa"}, claims(nil), otherKey),
+			err:   ErrTokenSignature,
+		},
+		{
+			name:  "unknown key",
+			token: signToken(t, map[string]interface{}{"alg": AlgorithmRS256, "kid": "other"}, claims(nil), rsaKey),
+			err:   ErrKeyNotFound,
+		},
+		{
+			name:  "key of other algorithm",
+			token: signToken(t, map[string]interface{}{"alg": AlgorithmES256, "kid": "rsa"}, claims(nil), ecKey),
+			err:   ErrKeyNotFound,
+		},
+		{
+			name:  "algorithm none",
+			token: signToken(t, map[string]interface{}{"alg": "none"}, claims(nil), []byte{}),
+			err:   ErrUnsupportedAlgorithm,
+		},
+		{
+			name:  "malformed",
+			token: "header.claims",
+			err:   ErrTokenMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verified, err := validator.Verify(tt.token)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && verified.Subject() != "alice" {
+				t.Errorf("expected subject alice, got %s", verified.Subject())
+			}
+		})
+	}
+}
+
+func TestJWTVerifyWithoutKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens must not be verified with a key of another algorithm.
+	validator := NewJWT(&JWTOptions{Secret: []byte("secret")})
+	claims := map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}
+	token := signToken(t, map[string]interface{}{"alg": AlgorithmRS256}, claims, rsaKey)
+
+	if _, err := validator.Verify(token); err != ErrKeyNotFound {
+		t.Fatalf("expected error %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := writeJWKS(t, map[string]interface{}{"old": &oldKey.PublicKey})
+	validator := NewJWT(&JWTOptions{JWKSFile: file})
+
+	claims := map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}
+	oldToken := signToken(t, map[string]interface{}{"alg": AlgorithmRS256, "kid": "old"}, claims, oldKey)
+	newToken := signToken(t, map[string]interface{}{"alg": AlgorithmRS256, "kid": "new"}, claims, newKey)
+
+	if _, err := validator.Verify(oldToken); err != nil {
+		t.Fatalf("expected old key to be valid, got %v", err)
+	}
+
+	rotated := writeJWKS(t, map[string]interface{}{"new": &newKey.PublicKey})
+	if err := os.Rename(rotated, file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown keys are only refreshed once per minimum refresh interval.
+	if _, err := validator.Verify(newToken); err != ErrKeyNotFound {
+		t.Fatalf("expected refresh to be throttled, got %v", err)
+	}
+
+	validator.keys.mutex.Lock()
+	validator.keys.attemptedAt = time.Now().Add(-2 * DefaultJWKSMinRefreshInterval)
+	validator.keys.mutex.Unlock()
+
+	if _, err := validator.Verify(newToken); err != nil {
+		t.Fatalf("expected new key to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains authorization policies for channels. A policy
+// consists of rules, which allow users or roles to perform actions
+// on resources. Resources are described by channel patterns with
+// the usual wildcards, for example:
+//
+//   {
+//     "rules": [
+//       { "roles": ["admin"], "channels": ["v1.>"], "actions": ["*"] },
+//       { "users": ["alice"], "channels": ["v1.mails"], "actions": ["create"] }
+//     ]
+//   }
+//
+// The action is the last token of a channel, such that the rule above
+// allows alice to publish to `v1.mails.create`. Everything that is not
+// explicitly allowed is denied.
+
+const (
+	ActionCreate = "create"
+	ActionRead   = "read"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionFind   = "find"
+	ActionAll    = "*"
+)
+
+var (
+	ErrPolicyMalformed = errors.New("auth: policy malformed")
+)
+
+// PolicyRule allows the users and roles to perform the actions on the channels.
+type PolicyRule struct {
+	Users    []string `json:"users"`
+	Roles    []string `json:"roles"`
+	Channels []string `json:"channels"`
+	Actions  []string `json:"actions"`
+}
+
+// Policy contains the rules that are evaluated to authorize a request.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy reads and validates a policy file.
+func LoadPolicy(file string) (*Policy, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := new(Policy)
+	if err := json.Unmarshal(raw, policy); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPolicyMalformed, err)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Validate checks that every rule has a subject, a channel and known actions.
+func (policy *Policy) Validate() error {
+	for i, rule := range policy.Rules {
+		if len(rule.Users) == 0 && len(rule.Roles) == 0 {
+			return fmt.Errorf("%w: rule %d has no users or roles", ErrPolicyMalformed, i)
+		}
+		if len(rule.Channels) == 0 {
+			return fmt.Errorf("%w: rule %d has no channels", ErrPolicyMalformed, i)
+		}
+		if len(rule.Actions) == 0 {
+			return fmt.Errorf("%w: rule %d has no actions", ErrPolicyMalformed, i)
+		}
+
+		for _, action := range rule.Actions {
+			switch action {
+			case ActionCreate, ActionRead, ActionUpdate, ActionDelete, ActionFind, ActionAll:
+			default:
+				return fmt.Errorf("%w: rule %d has unknown action: %s", ErrPolicyMalformed, i, action)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Allowed checks if any rule allows the user with the given roles
+// to perform the action, which is the last token of the channel.
+func (policy *Policy) Allowed(user string, roles []string, channel string) bool {
+	separator := strings.LastIndex(channel, broker.ChannelSeparator)
+	if separator < 0 {
+		return false
+	}
+	resource, action := channel[:separator], channel[separator+1:]
+
+	for _, rule := range policy.Rules {
+		if !contains(rule.Users, user) && !containsAny(rule.Roles, roles) {
+			continue
+		}
+		if !contains(rule.Actions, action) && !contains(rule.Actions, ActionAll) {
+			continue
+		}
+
+		for _, pattern := range rule.Channels {
+			if broker.MatchChannel(pattern, resource) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllowedPattern checks if any rule allows the user with the given roles
+// to perform the actions on all channels that match the pattern, such as
+// the pattern of a subscription. Patterns that are only partially covered
+// by a rule are denied.
+func (policy *Policy) AllowedPattern(user string, roles []string, pattern string) bool {
+	separator := strings.LastIndex(pattern, broker.ChannelSeparator)
+	if separator < 0 {
+		return false
+	}
+	resource, action := pattern[:separator], pattern[separator+1:]
+
+	for _, rule := range policy.Rules {
+		if !contains(rule.Users, user) && !containsAny(rule.Roles, roles) {
+			continue
+		}
+
+		for _, channel := range rule.Channels {
+			// A rule that allows all actions covers any action of its resources.
+			if contains(rule.Actions, ActionAll) && broker.CoversPattern(allActions(channel), pattern) {
+				return true
+			}
+
+			// Otherwise the action must be one of the allowed actions.
+			if contains(rule.Actions, action) && broker.CoversPattern(channel, resource) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allActions returns the pattern of all actions on the resources.
+func allActions(resource string) string {
+	if strings.HasSuffix(resource, broker.ChannelWildcardMulti) {
+		// The action is covered by the multi-level wildcard, which
+		// however requires at least one more level for the resource.
+		return strings.TrimSuffix(resource, broker.ChannelWildcardMulti) +
+			broker.ChannelWildcardSingle + broker.ChannelSeparator + broker.ChannelWildcardMulti
+	}
+
+	return resource + broker.ChannelSeparator + broker.ChannelWildcardSingle
+}
+
+// contains checks if the list contains the value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsAny checks if the list contains any of the values.
+func containsAny(list []string, values []string) bool {
+	for _, value := range values {
+		if contains(list, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Roles: []string{"admin"}, Channels: []string{"v1.>"}, Actions: []string{ActionAll}},
+			{Users: []string{"alice"}, Channels: []string{"v1.mails"}, Actions: []string{ActionCreate}},
+			{Users: []string{"bob"}, Channels: []string{"v1.pets", "v1.*.toys"}, Actions: []string{ActionRead, ActionFind}},
+			{Roles: []string{"reader"}, Channels: []string{"v1.pets"}, Actions: []string{ActionAll}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		roles   []string
+		channel string
+		allowed bool
+	}{
+		{name: "role with all actions", user: "carol", roles: []string{"admin"}, channel: "v1.pets.delete", allowed: true},
+		{name: "role with nested resource", user: "carol", roles: []string{"admin"}, channel: "v1.pets.toys.read", allowed: true},
+		{name: "role outside resources", user: "carol", roles: []string{"admin"}, channel: "v2.pets.read"},
+		{name: "user with action", user: "alice", channel: "v1.mails.create", allowed: true},
+		{name: "user without action", user: "alice", channel: "v1.mails.delete"},
+		{name: "user of other rule", user: "alice", channel: "v1.pets.read"},
+		{name: "single-level wildcard", user: "bob", channel: "v1.cats.toys.find", allowed: true},
+		{name: "single-level wildcard too deep", user: "bob", channel: "v1.cats.big.toys.find"},
+		{name: "unknown user", user: "mallory", roles: []string{"guest"}, channel: "v1.pets.read"},
+		{name: "channel without action", user: "carol", roles: []string{"admin"}, channel: "v1"},
+		// Wildcards of a request are not expanded, but matched literally.
+		{name: "wildcard action", user: "bob", channel: "v1.pets.*"},
+		{name: "wildcard resource", user: "alice", channel: "v1.*.create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := policy.Allowed(tt.user, tt.roles, tt.channel); allowed != tt.allowed {
+				t.Errorf("expected %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestPolicyAllowedPattern(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Roles: []string{"admin"}, Channels: []string{"v1.>"}, Actions: []string{ActionAll}},
+			{Users: []string{"alice"}, Channels: []string{"v1.pets"}, Actions: []string{ActionAll}},
+			{Users: []string{"bob"}, Channels: []string{"v1.pets", "v1.*.toys"}, Actions: []string{ActionRead}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		roles   []string
+		pattern string
+		allowed bool
+	}{
+		{name: "all channels of role", user: "carol", roles: []string{"admin"}, pattern: "v1.>"},
+		{name: "nested channels of role", user: "carol", roles: []string{"admin"}, pattern: "v1.pets.>", allowed: true},
+		{name: "single-level wildcards of role", user: "carol", roles: []string{"admin"}, pattern: "v1.*.*", allowed: true},
+		{name: "all actions of user", user: "alice", pattern: "v1.pets.*", allowed: true},
+		{name: "concrete channel of user", user: "alice", pattern: "v1.pets.read", allowed: true},
+		{name: "nested channels of user", user: "alice", pattern: "v1.pets.>"},
+		{name: "wider resource of user", user: "alice", pattern: "v1.*.read"},
+		{name: "everything of user", user: "alice", pattern: ">"},
+		{name: "allowed action", user: "bob", pattern: "v1.pets.read", allowed: true},
+		{name: "allowed action of wildcard resource", user: "bob", pattern: "v1.*.toys.read", allowed: true},
+		{name: "allowed action of covered resource", user: "bob", pattern: "v1.cats.toys.read", allowed: true},
+		{name: "wildcard action", user: "bob", pattern: "v1.pets.*"},
+		{name: "multi-level wildcard action", user: "bob", pattern: "v1.pets.>"},
+		{name: "wildcard resource", user: "bob", pattern: "v1.*.read"},
+		{name: "unknown user", user: "mallory", pattern: "v1.pets.read"},
+		{name: "pattern without action", user: "carol", roles: []string{"admin"}, pattern: "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := policy.AllowedPattern(tt.user, tt.roles, tt.pattern); allowed != tt.allowed {
+				t.Errorf("expected %v, got %v", tt.allowed, allowed)
+			}
+		})
+	}
+}
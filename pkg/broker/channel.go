@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"strings"
+)
+
+// This file contains helpers to work with canonical channels
+// as described in the concepts (see: docs/concepts.md).
+
+const (
+	// ChannelSeparator is used to separate hierarchy levels of a channel.
+	ChannelSeparator = "."
+	// ChannelWildcardSingle matches exactly one hierarchy level.
+	ChannelWildcardSingle = "*"
+	// ChannelWildcardMulti matches one or more trailing hierarchy levels.
+	ChannelWildcardMulti = ">"
+)
+
+// MatchChannel checks if a channel matches a channel pattern. The pattern
+// may contain a `*` to match a single hierarchy level or a trailing `>`
+// to match one or more hierarchy levels, just like a NATS subject.
+func MatchChannel(pattern string, channel string) bool {
+	patternTokens := strings.Split(pattern, ChannelSeparator)
+	channelTokens := strings.Split(channel, ChannelSeparator)
+
+	for i, token := range patternTokens {
+		// A multi-level wildcard consumes all remaining tokens,
+		// but requires at least one more token to be present.
+		if token == ChannelWildcardMulti {
+			return len(channelTokens) > i
+		}
+
+		// The channel is shorter than the pattern.
+		if i >= len(channelTokens) {
+			return false
+		}
+
+		if token != ChannelWildcardSingle && token != channelTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(channelTokens)
+}
+
+// CoversPattern checks if every channel that matches the pattern also
+// matches the allowed pattern. Unlike MatchChannel, it treats wildcards
+// in the pattern as wildcards, which is required to authorize the patterns
+// of subscriptions. For example, `v1.*` matches `v1.>`, but does not cover it.
+func CoversPattern(allowed string, pattern string) bool {
+	allowedTokens := strings.Split(allowed, ChannelSeparator)
+	patternTokens := strings.Split(pattern, ChannelSeparator)
+
+	for i, token := range allowedTokens {
+		if token == ChannelWildcardMulti {
+			return len(patternTokens) > i
+		}
+
+		if i >= len(patternTokens) {
+			return false
+		}
+
+		// A multi-level wildcard is only covered by another one.
+		if patternTokens[i] == ChannelWildcardMulti {
+			return false
+		}
+
+		if token != ChannelWildcardSingle && token != patternTokens[i] {
+			return false
+		}
+	}
+
+	return len(allowedTokens) == len(patternTokens)
+}
@@ -0,0 +1,42 @@
+package broker
+
+import (
+	"context"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+const (
+	// InboxPrefix is the channel prefix of the private reply inboxes.
+	InboxPrefix = "_INBOX"
+)
+
+// newEvent is a convenience function that creates a new service-specific cloud
+// event. The extensions stored in the context are added to the event.
+func newEvent(ctx context.Context, svc *service.Service, endpoint string, data interface{}) *cloudevents.Event {
+	// Assemble new cloud event.
+	event := cloudevents.NewEvent()
+	event.SetID(uuid.NewString())
+	event.SetSource(svc.Config.Name)
+	event.SetData(cloudevents.ApplicationJSON, data)
+
+	// Check if the event is directed towards a specific inbox.
+	if strings.Split(endpoint, ChannelSeparator)[0] == InboxPrefix {
+		event.SetType("response")
+	} else {
+		event.SetType(endpoint)
+	}
+
+	for name, value := range service.ExtensionsFromContext(ctx) {
+		event.SetExtension(name, value)
+	}
+
+	// Propagate the trace context of the publisher.
+	service.InjectTraceContext(ctx, &event)
+
+	return &event
+}
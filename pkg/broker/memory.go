@@ -0,0 +1,410 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the implementation of the Broker interface
+// based on golang channels. It can be used for monolithic,
+// single-binary apps, local development and integration tests.
+
+const (
+	DefaultMemoryPendingLimit = 256
+)
+
+// DefaultMemoryBus is the bus used by all in-memory brokers
+// that do not explicitly configure a bus.
+var DefaultMemoryBus = NewMemoryBus()
+
+type MemoryOptions struct {
+	Bus            *MemoryBus
+	RequestTimeout time.Duration
+	PendingLimit   int
+}
+
+// MemoryBus routes messages between in-memory brokers. Only
+// brokers that share the same bus can communicate.
+type MemoryBus struct {
+	subscriptions map[*memorySubscription]bool
+	mutex         *sync.RWMutex
+}
+
+type memoryMessage struct {
+	channel string
+	reply   string
+	data    []byte
+}
+
+type memorySubscription struct {
+	broker   *Memory
+	pattern  string
+	queue    string
+	messages chan *memoryMessage
+	done     chan bool
+	once     *sync.Once
+}
+
+// Unsubscribe removes a watching subscription from the bus.
+// Subsequent calls have no effect.
+func (sub *memorySubscription) Unsubscribe() error {
+	sub.once.Do(func() {
+		sub.broker.options.Bus.remove(sub)
+		close(sub.done)
+	})
+
+	return nil
+}
+
+type Memory struct {
+	service *service.Service
+	options *MemoryOptions
+
+	// The mutex guards the connection state and the subscriptions,
+	// as handlers and the admin listener access them concurrently.
+	connected           bool
+	activeSubscriptions map[string]*memorySubscription
+	queuedSubscriptions map[string]service.ChannelHandler
+	mutex               *sync.Mutex
+}
+
+// NewMemoryBus creates a new bus for in-memory brokers.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subscriptions: make(map[*memorySubscription]bool),
+		mutex:         &sync.RWMutex{},
+	}
+}
+
+func (bus *MemoryBus) add(sub *memorySubscription) {
+	bus.mutex.Lock()
+	bus.subscriptions[sub] = true
+	bus.mutex.Unlock()
+}
+
+func (bus *MemoryBus) remove(sub *memorySubscription) {
+	bus.mutex.Lock()
+	delete(bus.subscriptions, sub)
+	bus.mutex.Unlock()
+}
+
+// publish delivers the message to all matching subscriptions without
+// a queue group and to one random member of every matching queue group.
+func (bus *MemoryBus) publish(msg *memoryMessage) {
+	bus.mutex.RLock()
+	queueGroups := make(map[string][]*memorySubscription)
+	for sub := range bus.subscriptions {
+		if !MatchChannel(sub.pattern, msg.channel) {
+			continue
+		}
+
+		if sub.queue == "" {
+			sub.deliver(msg)
+			continue
+		}
+
+		// Queue groups are scoped per channel pattern, just like in NATS.
+		group := sub.pattern + " " + sub.queue
+		queueGroups[group] = append(queueGroups[group], sub)
+	}
+	bus.mutex.RUnlock()
+
+	for _, members := range queueGroups {
+		members[rand.Intn(len(members))].deliver(msg)
+	}
+}
+
+// deliver passes the message to the subscription without blocking the publisher.
+func (sub *memorySubscription) deliver(msg *memoryMessage) {
+	select {
+	case sub.messages <- msg:
+	default:
+		// Drop the message like NATS does for slow consumers.
+		if sub.broker != nil && sub.broker.service != nil {
+			sub.broker.service.Logger.Warn().Msgf("Slow consumer, dropping message: %s", msg.channel)
+		}
+	}
+}
+
+func NewMemory(opts *MemoryOptions) service.Broker {
+	if opts.Bus == nil {
+		opts.Bus = DefaultMemoryBus
+	}
+
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = 1000 * time.Millisecond
+	}
+
+	if opts.PendingLimit == 0 {
+		opts.PendingLimit = DefaultMemoryPendingLimit
+	}
+
+	return &Memory{
+		options:             opts,
+		activeSubscriptions: make(map[string]*memorySubscription),
+		queuedSubscriptions: make(map[string]service.ChannelHandler),
+		mutex:               &sync.Mutex{},
+	}
+}
+
+func (broker *Memory) Bind(svc *service.Service) {
+	broker.service = svc
+}
+
+func (broker *Memory) Subscribe(channel string, channelHandler service.ChannelHandler) error {
+	broker.mutex.Lock()
+	// Queue subscriptions that are made before connecting to the bus.
+	if !broker.connected || broker.service == nil {
+		broker.queuedSubscriptions[channel] = channelHandler
+		broker.mutex.Unlock()
+		return nil
+	}
+	replaced := broker.activeSubscriptions[channel]
+	broker.activeSubscriptions[channel] = broker.subscribe(channel, broker.service.Config.Name, channelHandler)
+	broker.mutex.Unlock()
+
+	// Close the previous subscription of the channel, whose handler is replaced.
+	if replaced != nil {
+		replaced.Unsubscribe()
+	}
+
+	// Attempt to register subscription without blocking, because the
+	// status service might live in the same process and start later.
+	go register(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *Memory) Unsubscribe(channel string) error {
+	broker.mutex.Lock()
+	sub := broker.activeSubscriptions[channel]
+	delete(broker.activeSubscriptions, channel)
+	broker.mutex.Unlock()
+
+	// Notify developer that there is a logic error
+	// when unsubscribing without prior subscription.
+	if sub == nil {
+		return service.ErrIllegalUnsubscribe
+	}
+
+	sub.Unsubscribe()
+
+	// Attempt to unregister subscription once, as the bus is local.
+	channelInfo := service.Channel{Name: channel}
+	broker.Request(ChannelUnsubscribe, channelInfo)
+
+	return nil
+}
+
+func (broker *Memory) Watch(channel string, channelHandler service.ChannelHandler) (service.Subscription, error) {
+	if !broker.Connected() {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	return broker.subscribe(channel, "", channelHandler), nil
+}
+
+func (broker *Memory) Publish(endpoint string, data interface{}) error {
+	return broker.PublishContext(context.Background(), endpoint, data)
+}
+
+func (broker *Memory) PublishContext(ctx context.Context, endpoint string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return broker.PublishEvent(endpoint, newEvent(ctx, broker.service, endpoint, data))
+}
+
+func (broker *Memory) PublishEvent(endpoint string, event *cloudevents.Event) error {
+	return broker.publish(endpoint, "", event)
+}
+
+func (broker *Memory) Request(endpoint string, data interface{}) (*service.Context, error) {
+	return broker.RequestContext(context.Background(), endpoint, data)
+}
+
+func (broker *Memory) RequestContext(ctx context.Context, endpoint string, data interface{}, opts ...service.RequestOption) (*service.Context, error) {
+	event := newEvent(ctx, broker.service, endpoint, data)
+
+	return request(ctx, broker.options.RequestTimeout, func(ctx context.Context) (*service.Context, error) {
+		return broker.request(ctx, endpoint, event)
+	}, opts...)
+}
+
+func (broker *Memory) Persist(channel service.PersistentChannel) error {
+	return service.ErrPersistenceUnsupported
+}
+
+func (broker *Memory) SubscribeStream(channel string, channelHandler service.ChannelHandler) error {
+	return service.ErrStreamsUnsupported
+}
+
+func (broker *Memory) OpenStream(ctx context.Context, endpoint string, data interface{}) (service.Stream, error) {
+	return nil, service.ErrStreamsUnsupported
+}
+
+func (broker *Memory) Connect() error {
+	broker.mutex.Lock()
+	broker.connected = true
+	queuedSubscriptions := broker.queuedSubscriptions
+	broker.queuedSubscriptions = make(map[string]service.ChannelHandler)
+	broker.mutex.Unlock()
+
+	// Subscribe to queued subscriptions.
+	for channel, channelHandler := range queuedSubscriptions {
+		if err := broker.Subscribe(channel, channelHandler); err != nil {
+			return err
+		}
+	}
+
+	// Log successful connection.
+	broker.service.Logger.Info().Msg("Connected to broker: memory")
+
+	return nil
+}
+
+func (broker *Memory) Disconnect() error {
+	broker.mutex.Lock()
+	channels := make([]string, 0, len(broker.activeSubscriptions))
+	for channel := range broker.activeSubscriptions {
+		channels = append(channels, channel)
+	}
+	broker.mutex.Unlock()
+
+	// Close all subscriptions manually to ensure that the channels are unregistered.
+	for _, channel := range channels {
+		if err := broker.Unsubscribe(channel); err != nil {
+			return err
+		}
+	}
+
+	broker.mutex.Lock()
+	broker.connected = false
+	broker.mutex.Unlock()
+
+	return nil
+}
+
+func (broker *Memory) Connected() bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	return broker.connected
+}
+
+// subscribe adds a subscription with an optional queue group to the bus.
+func (broker *Memory) subscribe(channel string, queue string, channelHandler service.ChannelHandler) *memorySubscription {
+	sub := &memorySubscription{
+		broker:   broker,
+		pattern:  channel,
+		queue:    queue,
+		messages: make(chan *memoryMessage, broker.options.PendingLimit),
+		done:     make(chan bool),
+		once:     &sync.Once{},
+	}
+
+	// Process messages sequentially, just like a NATS subscription.
+	go func() {
+		for {
+			select {
+			case msg := <-sub.messages:
+				broker.handle(msg, channelHandler)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	broker.options.Bus.add(sub)
+
+	return sub
+}
+
+// publish encodes the cloud event and passes it to the bus.
+func (broker *Memory) publish(endpoint string, reply string, event *cloudevents.Event) error {
+	if !broker.Connected() {
+		return service.ErrBrokerDisconnected
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	broker.options.Bus.publish(&memoryMessage{
+		channel: endpoint,
+		reply:   reply,
+		data:    encoded,
+	})
+
+	return nil
+}
+
+// request sends the event to a private inbox and waits for the reply.
+func (broker *Memory) request(ctx context.Context, endpoint string, event *cloudevents.Event) (*service.Context, error) {
+	if !broker.Connected() {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	// Create a private inbox to receive the reply.
+	inbox := &memorySubscription{
+		pattern:  InboxPrefix + ChannelSeparator + uuid.NewString(),
+		messages: make(chan *memoryMessage, 1),
+	}
+	broker.options.Bus.add(inbox)
+	defer broker.options.Bus.remove(inbox)
+
+	if err := broker.publish(endpoint, inbox.pattern, event); err != nil {
+		return nil, err
+	}
+
+	var msg *memoryMessage
+	select {
+	case msg = <-inbox.messages:
+	case <-ctx.Done():
+		return nil, service.ErrRequestTimeout
+	}
+
+	res := new(cloudevents.Event)
+	if err := json.Unmarshal(msg.data, res); err != nil {
+		return nil, err
+	}
+
+	return &service.Context{
+		Service:    broker.service,
+		Cloudevent: res,
+	}, nil
+}
+
+// handle decodes a message and invokes the channel handler.
+func (broker *Memory) handle(msg *memoryMessage, channelHandler service.ChannelHandler) {
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(msg.data, &event); err != nil {
+		broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+		return
+	}
+
+	// Store the reply channel in the event to allow replying.
+	if msg.reply != "" {
+		event.SetExtension(service.ExtensionReply, msg.reply)
+	}
+
+	// Invoke the channel handler with the user-defined business logic.
+	if handlerErr := channelHandler(&service.Context{
+		Service:    broker.service,
+		Cloudevent: &event,
+		Channel:    msg.channel,
+	}); handlerErr != nil {
+		broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+		return
+	}
+}
@@ -0,0 +1,206 @@
+package broker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// startMemory creates a bus and a status service, which replies
+// to the registrations of channels. It returns the bus.
+func startMemory(t *testing.T) *MemoryBus {
+	t.Helper()
+
+	bus := NewMemoryBus()
+
+	status := newMemoryService(t, "status", bus)
+	connect(t, status)
+
+	// The channels are subscribed after connecting to ensure that
+	// the channels are registered in order at the status service.
+	for _, channel := range []string{ChannelSubscribe, ChannelUnsubscribe} {
+		status.BrokerChannel(channel, func(ctx *service.Context) error {
+			return ctx.Reply(nil)
+		})
+	}
+
+	return bus
+}
+
+// newMemoryService creates a service with an in-memory broker, whose
+// channels are registered by the caller before connecting.
+func newMemoryService(t *testing.T, name string, bus *MemoryBus) *service.Service {
+	t.Helper()
+
+	svc := service.New(service.Config{Name: name})
+	svc.UseBroker(NewMemory(&MemoryOptions{Bus: bus, RequestTimeout: 200 * time.Millisecond}))
+
+	return svc
+}
+
+func TestMemoryRequest(t *testing.T) {
+	bus := startMemory(t)
+
+	pets := newMemoryService(t, "pets", bus)
+	pets.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	pets.BrokerChannel("v1.*.find", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	pets.BrokerChannel("v2.>", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	connect(t, pets)
+
+	client := newMemoryService(t, "client", bus)
+	connect(t, client)
+
+	tests := []struct {
+		name    string
+		channel string
+		err     error
+	}{
+		{name: "concrete channel", channel: "v1.pets.read"},
+		{name: "single-level wildcard", channel: "v1.toys.find"},
+		{name: "single-level wildcard too deep", channel: "v1.pets.toys.find", err: service.ErrRequestTimeout},
+		{name: "multi-level wildcard", channel: "v2.pets.toys.find"},
+		{name: "multi-level wildcard without level", channel: "v2", err: service.ErrRequestTimeout},
+		{name: "no responder", channel: "v1.pets.delete", err: service.ErrRequestTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client.Broker.Request(tt.channel, nil)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var channel string
+			if err := res.Cloudevent.DataAs(&channel); err != nil {
+				t.Fatal(err)
+			}
+			if channel != tt.channel {
+				t.Errorf("expected channel %s, got %s", tt.channel, channel)
+			}
+		})
+	}
+}
+
+func TestMemoryPublish(t *testing.T) {
+	bus := startMemory(t)
+
+	var handled, watched int32
+	for i := 0; i < 2; i++ {
+		instance := newMemoryService(t, "mail", bus)
+		instance.BrokerChannel("mails.create", func(ctx *service.Context) error {
+			atomic.AddInt32(&handled, 1)
+			return nil
+		})
+		connect(t, instance)
+
+		subscription, err := instance.Broker.Watch("mails.*", func(ctx *service.Context) error {
+			atomic.AddInt32(&watched, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { subscription.Unsubscribe() })
+	}
+
+	client := newMemoryService(t, "client", bus)
+	connect(t, client)
+
+	for i := 0; i < 4; i++ {
+		if err := client.Broker.Publish("mails.create", "mail"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Events are handled by one instance of the queue group and by all watchers.
+	eventually(time.Second, func() bool {
+		return atomic.LoadInt32(&handled) >= 4 && atomic.LoadInt32(&watched) >= 8
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	if handled := atomic.LoadInt32(&handled); handled != 4 {
+		t.Errorf("expected 4 handled events, got %d", handled)
+	}
+	if watched := atomic.LoadInt32(&watched); watched != 8 {
+		t.Errorf("expected 8 watched events, got %d", watched)
+	}
+}
+
+func TestMemoryUnsubscribe(t *testing.T) {
+	bus := startMemory(t)
+
+	svc := newMemoryService(t, "pets", bus)
+	svc.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply(nil)
+	})
+	connect(t, svc)
+
+	if err := svc.Broker.Unsubscribe("v1.pets.read"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Broker.Unsubscribe("v1.pets.read"); err != service.ErrIllegalUnsubscribe {
+		t.Errorf("expected error %v, got %v", service.ErrIllegalUnsubscribe, err)
+	}
+	if _, err := svc.Broker.Request("v1.pets.read", nil); err != service.ErrRequestTimeout {
+		t.Errorf("expected error %v, got %v", service.ErrRequestTimeout, err)
+	}
+
+	// Unsubscribing a watching subscription twice must not panic.
+	subscription, err := svc.Broker.Watch("pets.>", func(ctx *service.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := subscription.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryResubscribe(t *testing.T) {
+	bus := startMemory(t)
+
+	var first, second int32
+	svc := newMemoryService(t, "pets", bus)
+	connect(t, svc)
+
+	// Subscribing a channel again replaces the previous subscription.
+	svc.BrokerChannel("pets.create", func(ctx *service.Context) error {
+		atomic.AddInt32(&first, 1)
+		return nil
+	})
+	svc.BrokerChannel("pets.create", func(ctx *service.Context) error {
+		atomic.AddInt32(&second, 1)
+		return nil
+	})
+
+	if err := svc.Broker.Publish("pets.create", "rex"); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(time.Second, func() bool { return atomic.LoadInt32(&second) == 1 }) {
+		t.Fatalf("expected replacing subscription to receive the event, got %d", second)
+	}
+	if first := atomic.LoadInt32(&first); first != 0 {
+		t.Errorf("expected replaced subscription to receive no events, got %d", first)
+	}
+
+	if err := svc.Broker.Unsubscribe("pets.create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Broker.Unsubscribe("pets.create"); err != service.ErrIllegalUnsubscribe {
+		t.Errorf("expected error %v, got %v", service.ErrIllegalUnsubscribe, err)
+	}
+}
@@ -1,4 +0,0 @@
-package broker
-
-// TODO: Implement golang channel-based broker that
-// can be used for monolithic, single-binary apps.
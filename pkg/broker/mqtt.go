@@ -0,0 +1,871 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the implementation of the Broker interface
+// for MQTT 5 event brokers (https://mqtt.org/).
+
+const (
+	DefaultMQTTKeepAlive    = 30
+	DefaultMQTTPendingLimit = 256
+	// DefaultMQTTDuplicateWindow is the number of recent messages of a
+	// subscription that are remembered to drop duplicate copies.
+	DefaultMQTTDuplicateWindow = 16
+
+	// MQTTTopicSeparator is used to separate hierarchy levels of a topic.
+	MQTTTopicSeparator = "/"
+	// MQTTWildcardSingle matches exactly one hierarchy level.
+	MQTTWildcardSingle = "+"
+	// MQTTWildcardMulti matches all remaining hierarchy levels.
+	MQTTWildcardMulti = "#"
+	// MQTTSharedPrefix is the prefix of shared subscriptions.
+	MQTTSharedPrefix = "$share"
+)
+
+var (
+	ErrMQTTConnectionRefused  = errors.New("mqtt: connection refused")
+	ErrMQTTSubscriptionFailed = errors.New("mqtt: subscription failed")
+	ErrMQTTUnsupportedScheme  = errors.New("mqtt: unsupported uri scheme")
+
+	// DefaultMQTTReconnectPolicy is used to reestablish the connection
+	// to the server. Attempts are made until the broker is disconnected.
+	DefaultMQTTReconnectPolicy = &service.RetryPolicy{
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+)
+
+type MQTTOptions struct {
+	URI             string
+	ClientID        string
+	QoS             byte
+	KeepAlive       uint16
+	RequestTimeout  time.Duration
+	PendingLimit    int
+	TLSConfig       *tls.Config
+	ReconnectPolicy *service.RetryPolicy
+}
+
+type MQTT struct {
+	service             *service.Service
+	options             *MQTTOptions
+	uri                 *url.URL
+	redactedURI         string
+	clientID            string
+	client              *paho.Client
+	inbox               string
+	activeSubscriptions map[string]*mqttSubscription
+	queuedSubscriptions map[string]service.ChannelHandler
+	pendingRequests     map[string]chan *paho.Publish
+	// filters contains the topic filters that are subscribed at the server,
+	// which are shared by all subscriptions of the broker with the same filter.
+	filters      map[string]*mqttFilter
+	filterCount  int
+	subIDs       bool
+	connected    bool
+	disconnected bool
+	mutex        *sync.Mutex
+	// filtersMutex serializes subscribing and unsubscribing topic filters.
+	filtersMutex *sync.Mutex
+}
+
+// mqttFilter is a topic filter that is subscribed at the server. Only
+// shared filters have an identifier, which is sent by the server with
+// the messages that match it. Servers may merge the messages of
+// overlapping filters and clients only keep one identifier, which
+// therefore always belongs to a shared filter.
+type mqttFilter struct {
+	id            int
+	shared        bool
+	channel       string
+	subscriptions []*mqttSubscription
+}
+
+// mqttSubscription receives the messages of a topic filter
+// via the connection that is shared by all subscriptions.
+type mqttSubscription struct {
+	broker   *MQTT
+	filter   string
+	messages chan *paho.Publish
+	done     chan bool
+	once     *sync.Once
+	// recent contains the keys of the recently received messages, as
+	// servers may send a copy for each overlapping topic filter.
+	recent [][sha256.Size]byte
+}
+
+// Unsubscribe removes the subscription and unsubscribes
+// its topic filter if no other subscription uses it.
+func (sub *mqttSubscription) Unsubscribe() error {
+	return sub.broker.unwatch(sub)
+}
+
+// close stops processing the messages of the subscription.
+func (sub *mqttSubscription) close() {
+	sub.once.Do(func() {
+		close(sub.done)
+	})
+}
+
+// receive queues the message unless it is a copy of a recent message.
+func (sub *mqttSubscription) receive(msg *paho.Publish) {
+	// Requests are identified by their correlation data, because
+	// retried requests are published with the same event.
+	hash := sha256.New()
+	if msg.Properties != nil {
+		hash.Write(msg.Properties.CorrelationData)
+	}
+	hash.Write(msg.Payload)
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+
+	for _, recent := range sub.recent {
+		if recent == key {
+			return
+		}
+	}
+	sub.recent = append(sub.recent, key)
+	if len(sub.recent) > DefaultMQTTDuplicateWindow {
+		sub.recent = sub.recent[1:]
+	}
+
+	select {
+	case sub.messages <- msg:
+	default:
+		// Drop the message like NATS does for slow consumers.
+		sub.broker.service.Logger.Warn().Msgf("Slow consumer, dropping message: %s", ChannelFromMQTTTopic(msg.Topic))
+	}
+}
+
+// MQTTTopicFromChannel translates a channel into an MQTT topic,
+// for example `pets.>` becomes `pets/#`.
+func MQTTTopicFromChannel(channel string) string {
+	tokens := strings.Split(channel, ChannelSeparator)
+	for i, token := range tokens {
+		switch token {
+		case ChannelWildcardSingle:
+			tokens[i] = MQTTWildcardSingle
+		case ChannelWildcardMulti:
+			tokens[i] = MQTTWildcardMulti
+		}
+	}
+
+	return strings.Join(tokens, MQTTTopicSeparator)
+}
+
+// ChannelFromMQTTTopic translates an MQTT topic into a channel,
+// for example `pets/create` becomes `pets.create`.
+func ChannelFromMQTTTopic(topic string) string {
+	tokens := strings.Split(topic, MQTTTopicSeparator)
+	for i, token := range tokens {
+		switch token {
+		case MQTTWildcardSingle:
+			tokens[i] = ChannelWildcardSingle
+		case MQTTWildcardMulti:
+			tokens[i] = ChannelWildcardMulti
+		}
+	}
+
+	return strings.Join(tokens, ChannelSeparator)
+}
+
+func NewMQTT(opts *MQTTOptions) service.Broker {
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = 1000 * time.Millisecond
+	}
+
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultMQTTKeepAlive
+	}
+
+	if opts.PendingLimit == 0 {
+		opts.PendingLimit = DefaultMQTTPendingLimit
+	}
+
+	if opts.ReconnectPolicy == nil {
+		opts.ReconnectPolicy = DefaultMQTTReconnectPolicy
+	}
+
+	return &MQTT{
+		options:             opts,
+		activeSubscriptions: make(map[string]*mqttSubscription),
+		queuedSubscriptions: make(map[string]service.ChannelHandler),
+		pendingRequests:     make(map[string]chan *paho.Publish),
+		filters:             make(map[string]*mqttFilter),
+		mutex:               &sync.Mutex{},
+		filtersMutex:        &sync.Mutex{},
+	}
+}
+
+func (broker *MQTT) Bind(svc *service.Service) {
+	broker.service = svc
+}
+
+func (broker *MQTT) Subscribe(channel string, channelHandler service.ChannelHandler) error {
+	// Queue subscriptions that are made while not connected to the server.
+	broker.mutex.Lock()
+	if broker.client == nil || broker.service == nil {
+		broker.queuedSubscriptions[channel] = channelHandler
+		broker.mutex.Unlock()
+		return nil
+	}
+	broker.mutex.Unlock()
+
+	// Shared subscriptions provide the queue group semantics of NATS.
+	filter := strings.Join([]string{MQTTSharedPrefix, broker.service.Config.Name, MQTTTopicFromChannel(channel)}, MQTTTopicSeparator)
+	sub, err := broker.watch(channel, filter, channelHandler)
+	if err != nil {
+		return err
+	}
+
+	broker.mutex.Lock()
+	broker.activeSubscriptions[channel] = sub
+	broker.mutex.Unlock()
+
+	register(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *MQTT) Unsubscribe(channel string) error {
+	broker.mutex.Lock()
+	sub := broker.activeSubscriptions[channel]
+	delete(broker.activeSubscriptions, channel)
+	broker.mutex.Unlock()
+
+	// Notify developer that there is a logic error
+	// when unsubscribing without prior subscription.
+	if sub == nil {
+		return service.ErrIllegalUnsubscribe
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		return err
+	}
+
+	unregister(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *MQTT) Watch(channel string, channelHandler service.ChannelHandler) (service.Subscription, error) {
+	if broker.currentClient() == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	sub, err := broker.watch(channel, MQTTTopicFromChannel(channel), channelHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// watch adds a subscription to the topic filter, which is subscribed at
+// the server unless another subscription of the broker already uses it.
+func (broker *MQTT) watch(channel string, filter string, channelHandler service.ChannelHandler) (*mqttSubscription, error) {
+	broker.filtersMutex.Lock()
+	defer broker.filtersMutex.Unlock()
+
+	broker.mutex.Lock()
+	client := broker.client
+	f := broker.filters[filter]
+	if f == nil {
+		f = &mqttFilter{
+			shared:  strings.HasPrefix(filter, MQTTSharedPrefix+MQTTTopicSeparator),
+			channel: channel,
+		}
+		if f.shared {
+			broker.filterCount += 1
+			f.id = broker.filterCount
+		}
+	}
+	broker.mutex.Unlock()
+
+	if client == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	if len(f.subscriptions) == 0 {
+		if err := broker.subscribe(client, filter, f.id); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := &mqttSubscription{
+		broker:   broker,
+		filter:   filter,
+		messages: make(chan *paho.Publish, broker.options.PendingLimit),
+		done:     make(chan bool),
+		once:     &sync.Once{},
+	}
+
+	broker.mutex.Lock()
+	f.subscriptions = append(f.subscriptions, sub)
+	broker.filters[filter] = f
+	broker.mutex.Unlock()
+
+	// Process messages sequentially and outside of the router, which
+	// allows the channel handler to perform requests on its own.
+	go func() {
+		for {
+			select {
+			case msg := <-sub.messages:
+				broker.handle(msg, channelHandler)
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// unwatch removes the subscription and unsubscribes its topic
+// filter at the server if no other subscription uses it.
+func (broker *MQTT) unwatch(sub *mqttSubscription) error {
+	sub.close()
+
+	broker.filtersMutex.Lock()
+	defer broker.filtersMutex.Unlock()
+
+	broker.mutex.Lock()
+	client := broker.client
+	f := broker.filters[sub.filter]
+	removed := false
+	if f != nil {
+		for i, s := range f.subscriptions {
+			if s == sub {
+				f.subscriptions = append(f.subscriptions[:i], f.subscriptions[i+1:]...)
+				removed = true
+				break
+			}
+		}
+		if len(f.subscriptions) == 0 {
+			delete(broker.filters, sub.filter)
+		}
+	}
+	broker.mutex.Unlock()
+
+	// The subscription was already removed or the connection is lost,
+	// in which case the filter is not subscribed again on reconnect.
+	if !removed || len(f.subscriptions) > 0 || client == nil {
+		return nil
+	}
+
+	_, err := client.Unsubscribe(context.Background(), &paho.Unsubscribe{
+		Topics: []string{sub.filter},
+	})
+	return err
+}
+
+func (broker *MQTT) Publish(endpoint string, data interface{}) error {
+	return broker.PublishContext(context.Background(), endpoint, data)
+}
+
+func (broker *MQTT) PublishContext(ctx context.Context, endpoint string, data interface{}) error {
+	return broker.publishEvent(ctx, endpoint, newEvent(ctx, broker.service, endpoint, data))
+}
+
+func (broker *MQTT) PublishEvent(endpoint string, event *cloudevents.Event) error {
+	return broker.publishEvent(context.Background(), endpoint, event)
+}
+
+// publishEvent publishes the cloud event and adds the correlation data for responses.
+func (broker *MQTT) publishEvent(ctx context.Context, endpoint string, event *cloudevents.Event) error {
+	properties := &paho.PublishProperties{
+		ContentType: cloudevents.ApplicationCloudEventsJSON,
+	}
+
+	// Responses carry the correlation data of the request, which
+	// is the last hierarchy level of the private response topic.
+	if strings.HasPrefix(endpoint, InboxPrefix+ChannelSeparator) {
+		tokens := strings.Split(endpoint, ChannelSeparator)
+		properties.CorrelationData = []byte(tokens[len(tokens)-1])
+	}
+
+	return broker.publish(ctx, endpoint, properties, event)
+}
+
+func (broker *MQTT) Request(endpoint string, data interface{}) (*service.Context, error) {
+	return broker.RequestContext(context.Background(), endpoint, data)
+}
+
+func (broker *MQTT) RequestContext(ctx context.Context, endpoint string, data interface{}, opts ...service.RequestOption) (*service.Context, error) {
+	event := newEvent(ctx, broker.service, endpoint, data)
+
+	return request(ctx, broker.options.RequestTimeout, func(ctx context.Context) (*service.Context, error) {
+		return broker.request(ctx, endpoint, event)
+	}, opts...)
+}
+
+func (broker *MQTT) Persist(channel service.PersistentChannel) error {
+	return service.ErrPersistenceUnsupported
+}
+
+func (broker *MQTT) SubscribeStream(channel string, channelHandler service.ChannelHandler) error {
+	return service.ErrStreamsUnsupported
+}
+
+func (broker *MQTT) OpenStream(ctx context.Context, endpoint string, data interface{}) (service.Stream, error) {
+	return nil, service.ErrStreamsUnsupported
+}
+
+func (broker *MQTT) Connect() error {
+	// Ensure that URI is provided.
+	if broker.options.URI == "" {
+		broker.service.Logger.Fatal().Msgf("Configuration missing: BROKER_URI")
+	}
+
+	// Parse URI to redact secrets.
+	uri, err := url.Parse(broker.options.URI)
+	if err != nil {
+		broker.service.Logger.Fatal().Msgf("Configuration invalid: BROKER_URI")
+	}
+	broker.uri = uri
+	// Manually redact username and password rather than replacing it with xxx.
+	redacted := *uri
+	redacted.User = nil
+	broker.redactedURI = redacted.String()
+
+	// Client identifiers must be unique, because the server
+	// will otherwise disconnect the previous client.
+	broker.clientID = broker.options.ClientID
+	if broker.clientID == "" {
+		broker.clientID = broker.service.Config.Name + "-" + strings.Split(uuid.NewString(), "-")[0]
+	}
+
+	// Responses are received on the private inbox of this client.
+	broker.inbox = InboxPrefix + ChannelSeparator + broker.clientID
+
+	client, err := broker.dial()
+	if err != nil {
+		return err
+	}
+
+	broker.mutex.Lock()
+	broker.client = client
+	broker.connected = true
+	broker.disconnected = false
+	broker.mutex.Unlock()
+
+	if err := broker.subscribeQueued(); err != nil {
+		return err
+	}
+
+	// Log successful connection.
+	broker.service.Logger.Info().Msgf("Connected to broker: %s", broker.redactedURI)
+
+	return nil
+}
+
+func (broker *MQTT) Disconnect() error {
+	broker.mutex.Lock()
+	channels := make([]string, 0, len(broker.activeSubscriptions))
+	for channel := range broker.activeSubscriptions {
+		channels = append(channels, channel)
+	}
+	broker.mutex.Unlock()
+
+	// Close all subscriptions manually to ensure that the channels are unregistered.
+	for _, channel := range channels {
+		if err := broker.Unsubscribe(channel); err != nil {
+			return err
+		}
+	}
+
+	// Stop the remaining subscriptions, such as the ones created via `Watch()`.
+	broker.mutex.Lock()
+	for _, f := range broker.filters {
+		for _, sub := range f.subscriptions {
+			sub.close()
+		}
+	}
+	broker.filters = make(map[string]*mqttFilter)
+	client := broker.client
+	broker.client = nil
+	broker.connected = false
+	broker.disconnected = true
+	broker.mutex.Unlock()
+
+	if client == nil {
+		return nil
+	}
+
+	return client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+}
+
+// Connected reports whether the connection of the broker is established.
+// It is reestablished in the background once it is lost.
+func (broker *MQTT) Connected() bool {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	return broker.connected
+}
+
+// currentClient returns the client of the current connection,
+// which is nil while the broker is not connected.
+func (broker *MQTT) currentClient() *paho.Client {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	return broker.client
+}
+
+// subscribeQueued subscribes to the subscriptions that
+// were made while the broker was not connected.
+func (broker *MQTT) subscribeQueued() error {
+	broker.mutex.Lock()
+	queued := broker.queuedSubscriptions
+	broker.queuedSubscriptions = make(map[string]service.ChannelHandler)
+	broker.mutex.Unlock()
+
+	for channel, channelHandler := range queued {
+		if err := broker.Subscribe(channel, channelHandler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lost is called once the connection of the client is lost
+// and reestablishes the connection in the background.
+func (broker *MQTT) lost(client *paho.Client) {
+	broker.mutex.Lock()
+	current := broker.client == client && !broker.disconnected
+	if current {
+		broker.client = nil
+		broker.connected = false
+	}
+	broker.mutex.Unlock()
+
+	// Ignore clients that were replaced or closed deliberately.
+	if !current {
+		return
+	}
+
+	broker.service.Logger.Warn().Msgf("Disconnected from broker: %s", broker.redactedURI)
+
+	go broker.reconnect()
+}
+
+// reconnect attempts to reestablish the connection until it succeeds or
+// the broker is disconnected. The topic filters are subscribed again.
+func (broker *MQTT) reconnect() {
+	for attempt := 1; ; attempt++ {
+		time.Sleep(broker.options.ReconnectPolicy.Backoff(attempt))
+
+		broker.mutex.Lock()
+		disconnected := broker.disconnected
+		broker.mutex.Unlock()
+		if disconnected {
+			return
+		}
+
+		client, err := broker.dial()
+		if err != nil {
+			broker.service.Logger.Warn().Err(err).Msgf("Failed to reconnect to broker: %s", broker.redactedURI)
+			continue
+		}
+
+		broker.mutex.Lock()
+		if broker.disconnected {
+			broker.mutex.Unlock()
+			client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+			return
+		}
+		broker.client = client
+		broker.connected = true
+		broker.mutex.Unlock()
+
+		broker.service.Metrics.BrokerReconnects.Inc()
+		broker.service.Logger.Info().Msgf("Reconnected to broker: %s", broker.redactedURI)
+
+		if err := broker.subscribeQueued(); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to subscribe queued subscriptions")
+		}
+
+		return
+	}
+}
+
+// dial opens a new connection to the server and subscribes to the private
+// inbox and to the topic filters of the existing subscriptions.
+func (broker *MQTT) dial() (*paho.Client, error) {
+	var conn net.Conn
+	var err error
+	switch broker.uri.Scheme {
+	case "mqtt", "tcp":
+		conn, err = net.Dial("tcp", broker.uri.Host)
+	case "mqtts", "ssl", "tls":
+		conn, err = tls.Dial("tcp", broker.uri.Host, broker.options.TLSConfig)
+	default:
+		return nil, ErrMQTTUnsupportedScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var client *paho.Client
+	client = paho.NewClient(paho.ClientConfig{
+		ClientID: broker.clientID,
+		Conn:     conn,
+		Router:   paho.NewSingleHandlerRouter(broker.route),
+		OnClientError: func(err error) {
+			broker.lost(client)
+		},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			broker.lost(client)
+		},
+	})
+
+	connect := &paho.Connect{
+		ClientID:   broker.clientID,
+		KeepAlive:  broker.options.KeepAlive,
+		CleanStart: true,
+	}
+	if broker.uri.User != nil {
+		connect.Username = broker.uri.User.Username()
+		connect.UsernameFlag = true
+		if password, ok := broker.uri.User.Password(); ok {
+			connect.Password = []byte(password)
+			connect.PasswordFlag = true
+		}
+	}
+
+	connack, err := client.Connect(context.Background(), connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connack.ReasonCode != 0 {
+		conn.Close()
+		return nil, ErrMQTTConnectionRefused
+	}
+
+	broker.mutex.Lock()
+	broker.subIDs = connack.Properties == nil || connack.Properties.SubIDAvailable
+	filters := make(map[string]int, len(broker.filters))
+	for filter, f := range broker.filters {
+		filters[filter] = f.id
+	}
+	broker.mutex.Unlock()
+
+	// The session is not resumed, so all topic filters are subscribed again.
+	filters[MQTTTopicFromChannel(broker.inbox+ChannelSeparator+ChannelWildcardSingle)] = 0
+	for filter, id := range filters {
+		if err := broker.subscribe(client, filter, id); err != nil {
+			client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// subscribe subscribes the client to a topic filter. The identifier
+// is only sent if it is not zero and supported by the server.
+func (broker *MQTT) subscribe(client *paho.Client, filter string, id int) error {
+	subscribe := &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{
+			filter: {QoS: broker.options.QoS},
+		},
+	}
+
+	broker.mutex.Lock()
+	if id > 0 && broker.subIDs {
+		subscribe.Properties = &paho.SubscribeProperties{SubscriptionIdentifier: &id}
+	}
+	broker.mutex.Unlock()
+
+	suback, err := client.Subscribe(context.Background(), subscribe)
+	if err != nil {
+		return err
+	}
+
+	// Reason codes of 0x80 or greater indicate a failure.
+	for _, reason := range suback.Reasons {
+		if reason >= 0x80 {
+			return ErrMQTTSubscriptionFailed
+		}
+	}
+
+	return nil
+}
+
+// route passes responses to the pending requests and all other messages
+// to the subscriptions of the topic filters that match the topic.
+func (broker *MQTT) route(msg *paho.Publish) {
+	channel := ChannelFromMQTTTopic(msg.Topic)
+	if strings.HasPrefix(channel, broker.inbox+ChannelSeparator) {
+		broker.reply(msg)
+		return
+	}
+
+	// Wildcards must not match topics of the server, such as `$SYS`.
+	if strings.HasPrefix(msg.Topic, "$") {
+		return
+	}
+
+	var id *int
+	if msg.Properties != nil {
+		id = msg.Properties.SubscriptionIdentifier
+	}
+
+	// Servers send a message either once for all overlapping topic filters
+	// or once per topic filter. Subscriptions of regular topic filters thus
+	// receive all messages that match and drop copies. Messages of shared
+	// topic filters are only received by one instance, which is why they are
+	// identified by the subscription identifier if supported by the server.
+	var receivers []*mqttSubscription
+	broker.mutex.Lock()
+	for _, f := range broker.filters {
+		if f.shared && broker.subIDs {
+			if id != nil && *id == f.id {
+				receivers = append(receivers, f.subscriptions...)
+			}
+			continue
+		}
+
+		if MatchChannel(f.channel, channel) {
+			receivers = append(receivers, f.subscriptions...)
+		}
+	}
+	broker.mutex.Unlock()
+
+	for _, sub := range receivers {
+		sub.receive(msg)
+	}
+}
+
+// publish encodes the cloud event and publishes it.
+func (broker *MQTT) publish(ctx context.Context, endpoint string, properties *paho.PublishProperties, event *cloudevents.Event) error {
+	client := broker.currentClient()
+	if client == nil {
+		return service.ErrBrokerDisconnected
+	}
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Publish(ctx, &paho.Publish{
+		Topic:      MQTTTopicFromChannel(endpoint),
+		QoS:        broker.options.QoS,
+		Payload:    encoded,
+		Properties: properties,
+	})
+	return err
+}
+
+// request sends the event with a unique response topic and waits for the reply.
+func (broker *MQTT) request(ctx context.Context, endpoint string, event *cloudevents.Event) (*service.Context, error) {
+	if broker.currentClient() == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	// Emulate request-reply via the response topic and the correlation
+	// data of MQTT 5. The response topic is unique for every request.
+	correlationID := uuid.NewString()
+	replies := make(chan *paho.Publish, 1)
+	broker.mutex.Lock()
+	broker.pendingRequests[correlationID] = replies
+	broker.mutex.Unlock()
+
+	defer func() {
+		broker.mutex.Lock()
+		delete(broker.pendingRequests, correlationID)
+		broker.mutex.Unlock()
+	}()
+
+	if err := broker.publish(ctx, endpoint, &paho.PublishProperties{
+		ContentType:     cloudevents.ApplicationCloudEventsJSON,
+		ResponseTopic:   MQTTTopicFromChannel(broker.inbox + ChannelSeparator + correlationID),
+		CorrelationData: []byte(correlationID),
+	}, event); err != nil {
+		return nil, err
+	}
+
+	var msg *paho.Publish
+	select {
+	case msg = <-replies:
+	case <-ctx.Done():
+		return nil, service.ErrRequestTimeout
+	}
+
+	res := new(cloudevents.Event)
+	if err := json.Unmarshal(msg.Payload, res); err != nil {
+		return nil, err
+	}
+
+	return &service.Context{
+		Service:    broker.service,
+		Cloudevent: res,
+	}, nil
+}
+
+// reply passes incoming responses to the pending requests.
+func (broker *MQTT) reply(msg *paho.Publish) {
+	channel := ChannelFromMQTTTopic(msg.Topic)
+	correlationID := strings.TrimPrefix(channel, broker.inbox+ChannelSeparator)
+	if msg.Properties != nil && len(msg.Properties.CorrelationData) > 0 {
+		correlationID = string(msg.Properties.CorrelationData)
+	}
+
+	broker.mutex.Lock()
+	replies := broker.pendingRequests[correlationID]
+	broker.mutex.Unlock()
+
+	if replies != nil {
+		select {
+		case replies <- msg:
+		default:
+		}
+	}
+}
+
+// handle decodes a message and invokes the channel handler.
+func (broker *MQTT) handle(msg *paho.Publish, channelHandler service.ChannelHandler) {
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+		return
+	}
+
+	// Store the response topic in the event to allow replying.
+	if msg.Properties != nil && msg.Properties.ResponseTopic != "" {
+		event.SetExtension(service.ExtensionReply, ChannelFromMQTTTopic(msg.Properties.ResponseTopic))
+	}
+
+	// Invoke the channel handler with the user-defined business logic.
+	if handlerErr := channelHandler(&service.Context{
+		Service:    broker.service,
+		Cloudevent: &event,
+		Channel:    ChannelFromMQTTTopic(msg.Topic),
+	}); handlerErr != nil {
+		broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+		return
+	}
+}
@@ -0,0 +1,266 @@
+package broker
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// startMQTT starts an embedded MQTT 5 server and a status service, which
+// replies to the registrations of channels. It returns the URI of the server.
+func startMQTT(t *testing.T) (*mqtt.Server, string) {
+	t.Helper()
+
+	// Reserve a free port for the listener of the server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	server := mqtt.New(&mqtt.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	uri := "mqtt://" + address
+
+	status := newMQTTService(t, "status", uri)
+	connectMQTT(t, status)
+
+	// The channels are subscribed after connecting to ensure that
+	// the channels are registered in order at the status service.
+	for _, channel := range []string{ChannelSubscribe, ChannelUnsubscribe} {
+		status.BrokerChannel(channel, func(ctx *service.Context) error {
+			return ctx.Reply(nil)
+		})
+	}
+
+	return server, uri
+}
+
+// newMQTTService creates a service with an MQTT broker, whose
+// channels are registered by the caller before connecting.
+func newMQTTService(t *testing.T, name string, uri string) *service.Service {
+	t.Helper()
+
+	// The backoff allows the tests to observe a lost connection.
+	svc := service.New(service.Config{Name: name})
+	svc.UseBroker(NewMQTT(&MQTTOptions{
+		URI:             uri,
+		RequestTimeout:  200 * time.Millisecond,
+		ReconnectPolicy: &service.RetryPolicy{InitialBackoff: 100 * time.Millisecond},
+	}))
+
+	return svc
+}
+
+func connectMQTT(t *testing.T, svc *service.Service) {
+	t.Helper()
+
+	if err := svc.Broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Broker.Disconnect() })
+}
+
+// eventually waits until the condition is met or the timeout passed.
+func eventually(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return true
+}
+
+func TestMQTTRequest(t *testing.T) {
+	_, uri := startMQTT(t)
+
+	pets := newMQTTService(t, "pets", uri)
+	pets.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	pets.BrokerChannel("v1.*.find", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	connectMQTT(t, pets)
+
+	client := newMQTTService(t, "client", uri)
+	connectMQTT(t, client)
+
+	tests := []struct {
+		name    string
+		channel string
+		err     error
+	}{
+		{name: "concrete channel", channel: "v1.pets.read"},
+		{name: "single-level wildcard", channel: "v1.toys.find"},
+		{name: "wildcard too deep", channel: "v1.pets.toys.find", err: service.ErrRequestTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client.Broker.Request(tt.channel, nil)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var channel string
+			if err := res.Cloudevent.DataAs(&channel); err != nil {
+				t.Fatal(err)
+			}
+			if channel != tt.channel {
+				t.Errorf("expected channel %s, got %s", tt.channel, channel)
+			}
+		})
+	}
+}
+
+func TestMQTTQueueGroup(t *testing.T) {
+	_, uri := startMQTT(t)
+
+	var handled, watched int32
+	for i := 0; i < 2; i++ {
+		instance := newMQTTService(t, "mail", uri)
+		instance.BrokerChannel("mails.create", func(ctx *service.Context) error {
+			atomic.AddInt32(&handled, 1)
+			return nil
+		})
+		connectMQTT(t, instance)
+
+		// The pattern overlaps with the channel of the shared subscription.
+		subscription, err := instance.Broker.Watch("mails.*", func(ctx *service.Context) error {
+			atomic.AddInt32(&watched, 1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { subscription.Unsubscribe() })
+	}
+
+	client := newMQTTService(t, "client", uri)
+	connectMQTT(t, client)
+
+	for i := 0; i < 4; i++ {
+		if err := client.Broker.Publish("mails.create", "mail"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eventually(time.Second, func() bool {
+		return atomic.LoadInt32(&handled) >= 4 && atomic.LoadInt32(&watched) >= 8
+	})
+	// Wait for duplicate deliveries.
+	time.Sleep(100 * time.Millisecond)
+
+	if handled := atomic.LoadInt32(&handled); handled != 4 {
+		t.Errorf("expected 4 handled events, got %d", handled)
+	}
+	if watched := atomic.LoadInt32(&watched); watched != 8 {
+		t.Errorf("expected 8 watched events, got %d", watched)
+	}
+}
+
+func TestMQTTWatch(t *testing.T) {
+	_, uri := startMQTT(t)
+
+	svc := newMQTTService(t, "gateway", uri)
+	connectMQTT(t, svc)
+
+	// Both subscriptions share the same topic filter.
+	var first, second int32
+	firstSubscription, err := svc.Broker.Watch("pets.>", func(ctx *service.Context) error {
+		atomic.AddInt32(&first, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondSubscription, err := svc.Broker.Watch("pets.>", func(ctx *service.Context) error {
+		atomic.AddInt32(&second, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer secondSubscription.Unsubscribe()
+
+	if err := svc.Broker.Publish("pets.create", "rex"); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(time.Second, func() bool { return atomic.LoadInt32(&first) == 1 && atomic.LoadInt32(&second) == 1 }) {
+		t.Fatalf("expected both subscriptions to receive the event, got %d and %d", first, second)
+	}
+
+	// Unsubscribing must be idempotent and keep the topic filter of the other subscription.
+	for i := 0; i < 2; i++ {
+		if err := firstSubscription.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := svc.Broker.Publish("pets.delete", "rex"); err != nil {
+		t.Fatal(err)
+	}
+	if !eventually(time.Second, func() bool { return atomic.LoadInt32(&second) == 2 }) {
+		t.Fatalf("expected second subscription to receive the event, got %d", second)
+	}
+	if first := atomic.LoadInt32(&first); first != 1 {
+		t.Errorf("expected first subscription to receive no more events, got %d", first)
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	server, uri := startMQTT(t)
+
+	pets := newMQTTService(t, "pets", uri)
+	pets.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply("rex")
+	})
+	connectMQTT(t, pets)
+
+	client := newMQTTService(t, "client", uri)
+	connectMQTT(t, client)
+
+	// Drop the connections of all clients.
+	for _, cl := range server.Clients.GetAll() {
+		cl.Stop(errors.New("dropped"))
+	}
+
+	if !eventually(time.Second, func() bool { return !pets.Broker.Connected() && !client.Broker.Connected() }) {
+		t.Fatal("expected brokers to detect the lost connection")
+	}
+	if !eventually(2*time.Second, func() bool { return pets.Broker.Connected() && client.Broker.Connected() }) {
+		t.Fatal("expected brokers to reconnect")
+	}
+
+	// The subscriptions and the inbox must be subscribed again.
+	if _, err := client.Broker.Request("v1.pets.read", nil); err != nil {
+		t.Fatalf("expected reply after reconnect, got %v", err)
+	}
+}
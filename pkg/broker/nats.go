@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"context"
 	"encoding/json"
 	"net/url"
 	"strings"
@@ -8,7 +9,6 @@ import (
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
-	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 
 	"github.com/nicklasfrahm/showcases/pkg/service"
@@ -17,27 +17,39 @@ import (
 // This file contains the implementation of the Broker interface
 // for the NATS event broker and message queue (https://nats.io/).
 
-// TODO: Add a `.Reply()` function to the broker that handles replying more elegantly.
-// TODO: Add a `.Broadcast()` function that enables or disables broadcasting after handler completion.
-// TODO: Create a canonical channel format for responses (`*.response`), handler success (`*.success`) and handler failure (`*.failure`).
-
 const (
 	ChannelSubscribe   = "channels.create"
 	ChannelUnsubscribe = "channels.delete"
+
+	DefaultPersistentMaxDeliver = 5
+	DefaultPersistentAckWait    = 30 * time.Second
+	// DefaultPersistentRetryDelay is the delay of the first redelivery
+	// of a rejected event, which doubles with every further delivery.
+	DefaultPersistentRetryDelay = 1 * time.Second
 )
 
 type NATSOptions struct {
 	URI            string
 	NATSOptions    []nats.Option
 	RequestTimeout time.Duration
+
+	// StreamWindow is the number of chunks that a peer may send
+	// before it has to wait for the chunks to be read.
+	StreamWindow    int
+	StreamChunkSize int
+	// StreamTimeout limits how long a stream waits for the peer.
+	StreamTimeout time.Duration
 }
 
 type NATS struct {
 	service             *service.Service
 	options             *NATSOptions
 	natsConn            *nats.Conn
+	jetStream           nats.JetStreamContext
 	activeSubscriptions map[string]*nats.Subscription
 	queuedSubscriptions map[string]service.ChannelHandler
+	queuedStreams       map[string]service.ChannelHandler
+	persistentChannels  map[string]service.PersistentChannel
 	mutex               *sync.Mutex
 }
 
@@ -46,10 +58,24 @@ func NewNATS(opts *NATSOptions) service.Broker {
 		opts.RequestTimeout = 1000 * time.Millisecond
 	}
 
+	if opts.StreamWindow == 0 {
+		opts.StreamWindow = DefaultStreamWindow
+	}
+
+	if opts.StreamChunkSize == 0 {
+		opts.StreamChunkSize = DefaultStreamChunkSize
+	}
+
+	if opts.StreamTimeout == 0 {
+		opts.StreamTimeout = DefaultStreamTimeout
+	}
+
 	return &NATS{
 		options:             opts,
 		activeSubscriptions: make(map[string]*nats.Subscription),
 		queuedSubscriptions: make(map[string]service.ChannelHandler),
+		queuedStreams:       make(map[string]service.ChannelHandler),
+		persistentChannels:  make(map[string]service.PersistentChannel),
 		mutex:               &sync.Mutex{},
 	}
 }
@@ -65,25 +91,38 @@ func (broker *NATS) Subscribe(channel string, channelHandler service.ChannelHand
 		return nil
 	}
 
-	subscription, err := broker.natsConn.QueueSubscribe(channel, broker.service.Config.Name, func(msg *nats.Msg) {
-		event := cloudevents.NewEvent()
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
-			broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
-			return
-		}
-
-		// Rewrite source to response topic.
-		event.SetSource(msg.Reply)
+	broker.mutex.Lock()
+	persistentChannel, persistent := broker.persistentChannels[channel]
+	broker.mutex.Unlock()
 
-		// Invoke the channel handler with the user-defined business logic.
-		if handlerErr := channelHandler(&service.Context{
-			Service:    broker.service,
-			Cloudevent: &event,
-		}); handlerErr != nil {
-			broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
-			return
-		}
-	})
+	var subscription *nats.Subscription
+	var err error
+	if persistent {
+		subscription, err = broker.subscribePersistent(persistentChannel, channelHandler)
+	} else {
+		subscription, err = broker.natsConn.QueueSubscribe(channel, broker.service.Config.Name, func(msg *nats.Msg) {
+			event := cloudevents.NewEvent()
+			if err := json.Unmarshal(msg.Data, &event); err != nil {
+				broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+				return
+			}
+
+			// Store the reply channel in the event to allow replying.
+			if msg.Reply != "" {
+				event.SetExtension(service.ExtensionReply, msg.Reply)
+			}
+
+			// Invoke the channel handler with the user-defined business logic.
+			if handlerErr := channelHandler(&service.Context{
+				Service:    broker.service,
+				Cloudevent: &event,
+				Channel:    msg.Subject,
+			}); handlerErr != nil {
+				broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+				return
+			}
+		})
+	}
 	if err != nil {
 		return err
 	}
@@ -92,47 +131,76 @@ func (broker *NATS) Subscribe(channel string, channelHandler service.ChannelHand
 	broker.activeSubscriptions[channel] = subscription
 	broker.mutex.Unlock()
 
-	// Attempt to register subscription.
-	channelInfo := service.Channel{Name: channel}
-	maxAttempts := 10
-	for attempts := 1; attempts <= maxAttempts; attempts++ {
-		if _, err := broker.Request(ChannelSubscribe, channelInfo); err == nil {
-			break
-		}
-		time.Sleep(time.Duration(attempts) * time.Second)
-	}
-	// TODO: How to handle or log that there is no status service?
+	register(broker, broker.service, channel)
 
 	return nil
 }
 
 func (broker *NATS) Unsubscribe(channel string) error {
+	broker.mutex.Lock()
+	subscription := broker.activeSubscriptions[channel]
+	broker.mutex.Unlock()
+
 	// Notify developer that there is a logic error
 	// when unsubscribing without prior subscription.
-	if broker.activeSubscriptions[channel] == nil {
+	if subscription == nil {
 		return service.ErrIllegalUnsubscribe
 	}
 
-	if err := broker.activeSubscriptions[channel].Unsubscribe(); err != nil {
+	if err := subscription.Unsubscribe(); err != nil {
 		return err
 	}
 
-	// Attempt to register subscription.
-	channelInfo := service.Channel{Name: channel}
-	maxAttempts := 10
-	for attempts := 1; attempts <= maxAttempts; attempts++ {
-		if _, err := broker.Request(ChannelUnsubscribe, channelInfo); err == nil {
-			break
+	broker.mutex.Lock()
+	delete(broker.activeSubscriptions, channel)
+	broker.mutex.Unlock()
+
+	unregister(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *NATS) Watch(channel string, channelHandler service.ChannelHandler) (service.Subscription, error) {
+	if broker.natsConn == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	subscription, err := broker.natsConn.Subscribe(channel, func(msg *nats.Msg) {
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+			return
+		}
+
+		if handlerErr := channelHandler(&service.Context{
+			Service:    broker.service,
+			Cloudevent: &event,
+			Channel:    msg.Subject,
+		}); handlerErr != nil {
+			broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
 		}
-		time.Sleep(time.Duration(attempts) * 100 * time.Millisecond)
+	})
+	if err != nil {
+		return nil, err
 	}
-	// TODO: How to handle or log that there is no status service?
 
-	return nil
+	return subscription, nil
 }
 
 func (broker *NATS) Publish(endpoint string, data interface{}) error {
-	encoded, err := json.Marshal(broker.newEvent(endpoint, data))
+	return broker.PublishContext(context.Background(), endpoint, data)
+}
+
+func (broker *NATS) PublishContext(ctx context.Context, endpoint string, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return broker.PublishEvent(endpoint, newEvent(ctx, broker.service, endpoint, data))
+}
+
+func (broker *NATS) PublishEvent(endpoint string, event *cloudevents.Event) error {
+	encoded, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
@@ -141,25 +209,36 @@ func (broker *NATS) Publish(endpoint string, data interface{}) error {
 }
 
 func (broker *NATS) Request(endpoint string, data interface{}) (*service.Context, error) {
-	encoded, err := json.Marshal(broker.newEvent(endpoint, data))
-	if err != nil {
-		return nil, err
+	return broker.RequestContext(context.Background(), endpoint, data)
+}
+
+func (broker *NATS) RequestContext(ctx context.Context, endpoint string, data interface{}, opts ...service.RequestOption) (*service.Context, error) {
+	event := newEvent(ctx, broker.service, endpoint, data)
+
+	return request(ctx, broker.options.RequestTimeout, func(ctx context.Context) (*service.Context, error) {
+		return broker.request(ctx, endpoint, event)
+	}, opts...)
+}
+
+func (broker *NATS) Persist(channel service.PersistentChannel) error {
+	if channel.MaxDeliver == 0 {
+		channel.MaxDeliver = DefaultPersistentMaxDeliver
 	}
 
-	msg, err := broker.natsConn.Request(endpoint, encoded, broker.options.RequestTimeout)
-	if err != nil {
-		return nil, err
+	if channel.AckWait == 0 {
+		channel.AckWait = DefaultPersistentAckWait
 	}
 
-	res := new(cloudevents.Event)
-	if err := json.Unmarshal(msg.Data, res); err != nil {
-		return nil, err
+	broker.mutex.Lock()
+	broker.persistentChannels[channel.Name] = channel
+	broker.mutex.Unlock()
+
+	// Declare the stream once connected if the server is not yet connected.
+	if broker.natsConn == nil {
+		return nil
 	}
 
-	return &service.Context{
-		Service:    broker.service,
-		Cloudevent: res,
-	}, nil
+	return broker.declareStream(channel)
 }
 
 func (broker *NATS) Connect() error {
@@ -187,6 +266,7 @@ func (broker *NATS) Connect() error {
 			broker.service.Logger.Warn().Err(err).Msgf("Disconnected from broker: %s", redactedBrokerURI)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
+			broker.service.Metrics.BrokerReconnects.Inc()
 			broker.service.Logger.Info().Msgf("Reconnected to broker: %s", redactedBrokerURI)
 		}),
 	}
@@ -199,6 +279,24 @@ func (broker *NATS) Connect() error {
 	}
 	broker.natsConn = natsConn
 
+	// Declare the streams of persistent channels.
+	jetStream, err := natsConn.JetStream()
+	if err != nil {
+		return err
+	}
+	broker.jetStream = jetStream
+	broker.mutex.Lock()
+	persistentChannels := make([]service.PersistentChannel, 0, len(broker.persistentChannels))
+	for _, channel := range broker.persistentChannels {
+		persistentChannels = append(persistentChannels, channel)
+	}
+	broker.mutex.Unlock()
+	for _, channel := range persistentChannels {
+		if err := broker.declareStream(channel); err != nil {
+			return err
+		}
+	}
+
 	// Subscribe to queued subscriptions.
 	for channel := range broker.queuedSubscriptions {
 		if err := broker.Subscribe(channel, broker.queuedSubscriptions[channel]); err != nil {
@@ -208,6 +306,15 @@ func (broker *NATS) Connect() error {
 		delete(broker.queuedSubscriptions, channel)
 	}
 
+	// Accept queued streams.
+	for channel := range broker.queuedStreams {
+		if err := broker.SubscribeStream(channel, broker.queuedStreams[channel]); err != nil {
+			return err
+		}
+
+		delete(broker.queuedStreams, channel)
+	}
+
 	// Log successful connection.
 	broker.service.Logger.Info().Msgf("Connected to broker: %s", redactedBrokerURI)
 
@@ -215,8 +322,15 @@ func (broker *NATS) Connect() error {
 }
 
 func (broker *NATS) Disconnect() error {
-	// Close all subscriptions manually to ensure that the channels are unregistered.
+	broker.mutex.Lock()
+	channels := make([]string, 0, len(broker.activeSubscriptions))
 	for channel := range broker.activeSubscriptions {
+		channels = append(channels, channel)
+	}
+	broker.mutex.Unlock()
+
+	// Close all subscriptions manually to ensure that the channels are unregistered.
+	for _, channel := range channels {
 		if err := broker.Unsubscribe(channel); err != nil {
 			return err
 		}
@@ -226,20 +340,197 @@ func (broker *NATS) Disconnect() error {
 	return broker.natsConn.Drain()
 }
 
-// newEvent is a convenience function that creates a new service-specific cloud event.
-func (broker *NATS) newEvent(endpoint string, data interface{}) *cloudevents.Event {
-	// Assemble new cloud event.
-	event := cloudevents.NewEvent()
-	event.SetID(uuid.NewString())
-	event.SetSource(broker.service.Config.Name)
-	event.SetData(cloudevents.ApplicationJSON, data)
+func (broker *NATS) Connected() bool {
+	return broker.natsConn != nil && broker.natsConn.IsConnected()
+}
 
-	// Check if the event is directed towards a specific inbox.
-	if strings.Split(endpoint, ".")[0] == "_INBOX" {
-		event.SetType("response")
-	} else {
-		event.SetType(endpoint)
+// declareStream creates a JetStream stream for a persistent channel if it does
+// not exist yet. The stream does not acknowledge published messages, because
+// the acknowledgement would otherwise be received as response to a request.
+// The stream is a work queue, which retains messages until the consumer of
+// the service acknowledged them, even if no instance of the service has
+// created the consumer yet. A work queue only allows a single consumer,
+// which is why a persistent channel may only be consumed by one service.
+func (broker *NATS) declareStream(channel service.PersistentChannel) error {
+	name := streamName(channel.Name)
+	if _, err := broker.jetStream.StreamInfo(name); err != nats.ErrStreamNotFound {
+		return err
+	}
+
+	_, err := broker.jetStream.AddStream(&nats.StreamConfig{
+		Name:      name,
+		Subjects:  []string{channel.Name},
+		Retention: nats.WorkQueuePolicy,
+		Storage:   nats.FileStorage,
+		NoAck:     true,
+	})
+	return err
+}
+
+// declareConsumer creates the durable consumer of the service for a persistent
+// channel if it does not exist yet. The consumer is not created implicitly by
+// the subscription, as it would otherwise be deleted when unsubscribing. An
+// existing consumer is updated if the configuration of the channel changed.
+func (broker *NATS) declareConsumer(channel service.PersistentChannel) error {
+	name := broker.service.Config.Name
+	info, err := broker.jetStream.ConsumerInfo(streamName(channel.Name), name)
+	if err == nil {
+		if info.Config.AckWait == channel.AckWait && info.Config.MaxDeliver == channel.MaxDeliver {
+			return nil
+		}
+
+		config := info.Config
+		config.AckWait = channel.AckWait
+		config.MaxDeliver = channel.MaxDeliver
+		_, err := broker.jetStream.UpdateConsumer(streamName(channel.Name), &config)
+		return err
+	}
+	if err != nats.ErrConsumerNotFound {
+		return err
+	}
+
+	_, err = broker.jetStream.AddConsumer(streamName(channel.Name), &nats.ConsumerConfig{
+		Durable:        name,
+		DeliverSubject: nats.NewInbox(),
+		DeliverGroup:   name,
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        channel.AckWait,
+		MaxDeliver:     channel.MaxDeliver,
+	})
+	return err
+}
+
+// subscribePersistent creates a durable subscription that is shared by all
+// instances of the service and acknowledges events based on the handler result.
+// Rejected events are redelivered with an exponentially increasing delay.
+func (broker *NATS) subscribePersistent(channel service.PersistentChannel, channelHandler service.ChannelHandler) (*nats.Subscription, error) {
+	if err := broker.declareConsumer(channel); err != nil {
+		return nil, err
+	}
+
+	return broker.jetStream.QueueSubscribe(channel.Name, broker.service.Config.Name, func(msg *nats.Msg) {
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+			// Malformed events will never succeed and are therefore not redelivered.
+			msg.Term()
+			return
+		}
+
+		// The event is only redelivered if this is not the last delivery.
+		delivered := uint64(1)
+		if metadata, err := msg.Metadata(); err == nil {
+			delivered = metadata.NumDelivered
+		}
+		retryable := delivered < uint64(channel.MaxDeliver)
+
+		// Invoke the channel handler with the user-defined business logic.
+		if handlerErr := channelHandler(&service.Context{
+			Service:    broker.service,
+			Cloudevent: &event,
+			Channel:    msg.Subject,
+			Retryable:  retryable,
+		}); handlerErr != nil {
+			broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+
+			// Client errors will never succeed and are therefore not redelivered.
+			if service.ClientError(handlerErr) {
+				if err := msg.Term(); err != nil {
+					broker.service.Logger.Warn().Err(err).Msg("Failed to reject event")
+				}
+				return
+			}
+
+			// Events are not redelivered after the last delivery.
+			if !retryable {
+				broker.service.Logger.Warn().Msgf("Maximum deliveries reached: %s", channel.Name)
+				if err := msg.Term(); err != nil {
+					broker.service.Logger.Warn().Err(err).Msg("Failed to reject event")
+				}
+				return
+			}
+
+			if err := msg.NakWithDelay(retryDelay(delivered, channel.AckWait)); err != nil {
+				broker.service.Logger.Warn().Err(err).Msg("Failed to reject event")
+			}
+			return
+		}
+
+		if err := msg.Ack(); err != nil {
+			broker.service.Logger.Warn().Err(err).Msg("Failed to acknowledge event")
+		}
+	},
+		nats.Bind(streamName(channel.Name), broker.service.Config.Name),
+		nats.ManualAck(),
+	)
+}
+
+// request sends the event to a private inbox and waits for the reply.
+func (broker *NATS) request(ctx context.Context, endpoint string, event *cloudevents.Event) (*service.Context, error) {
+	// Subscribe to a private inbox before sending the request.
+	inbox := nats.NewInbox()
+	sub, err := broker.natsConn.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
 	}
+	defer sub.Unsubscribe()
+
+	// Persistent channels do not preserve the reply subject of
+	// the message. It is therefore also stored in the event.
+	event.SetExtension(service.ExtensionReply, inbox)
+
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := broker.natsConn.PublishRequest(endpoint, inbox, encoded); err != nil {
+		return nil, err
+	}
+
+	msg, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, service.ErrRequestTimeout
+		}
+		if err == nats.ErrNoResponders {
+			return nil, service.ErrNoResponders
+		}
+		return nil, err
+	}
+
+	res := new(cloudevents.Event)
+	if err := json.Unmarshal(msg.Data, res); err != nil {
+		return nil, err
+	}
+
+	return &service.Context{
+		Service:    broker.service,
+		Cloudevent: res,
+	}, nil
+}
+
+// retryDelay returns the delay before an event is redelivered, which doubles
+// with every delivery, but does not exceed the acknowledgement timeout.
+func retryDelay(delivered uint64, ackWait time.Duration) time.Duration {
+	delay := DefaultPersistentRetryDelay
+	for i := uint64(1); i < delivered && delay < ackWait; i++ {
+		delay *= 2
+	}
+
+	if delay > ackWait {
+		return ackWait
+	}
+
+	return delay
+}
 
-	return &event
+// streamName converts a channel into a valid stream name.
+func streamName(channel string) string {
+	return strings.ToUpper(strings.NewReplacer(
+		ChannelSeparator, "_",
+		ChannelWildcardSingle, "ANY",
+		ChannelWildcardMulti, "ALL",
+	).Replace(channel))
 }
@@ -0,0 +1,468 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats.go"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the implementation of bi-directional streams for
+// the NATS broker. Both peers subscribe to a private inbox and send frames
+// to the inbox of the other peer. Data frames are numbered to ensure their
+// order and the sender may only have a window of unacknowledged frames.
+
+const (
+	DefaultStreamWindow    = 16
+	DefaultStreamChunkSize = 64 * 1024
+	DefaultStreamTimeout   = 30 * time.Second
+
+	streamHeaderFrame  = "Stream-Frame"
+	streamHeaderSeq    = "Stream-Seq"
+	streamHeaderWindow = "Stream-Window"
+
+	streamFrameOpen   = "open"
+	streamFrameAccept = "accept"
+	streamFrameData   = "data"
+	streamFrameAck    = "ack"
+	streamFrameClose  = "close"
+	streamFrameCancel = "cancel"
+
+	// The server replies with this status if nobody is subscribed
+	// to the subject of a message that expects a reply.
+	natsHeaderStatus       = "Status"
+	natsStatusNoResponders = "503"
+)
+
+type natsStreamFrame struct {
+	data  []byte
+	close bool
+}
+
+type natsStream struct {
+	broker       *NATS
+	subscription *nats.Subscription
+	inbox        string
+	peer         string
+	peerWindow   uint64
+	// accepted receives nil once the peer accepted the stream or
+	// an error if nobody is subscribed to the opened channel.
+	accepted chan error
+
+	mutex   *sync.Mutex
+	changed chan bool
+
+	// Receiving side of the stream.
+	frames  map[uint64]*natsStreamFrame
+	read    uint64
+	buffer  []byte
+	eof     bool
+	discard bool
+
+	// Sending side of the stream.
+	sent        uint64
+	acked       uint64
+	writeClosed bool
+	canceled    bool
+}
+
+func newNATSStream(broker *NATS) *natsStream {
+	return &natsStream{
+		broker:   broker,
+		inbox:    nats.NewInbox(),
+		accepted: make(chan error, 1),
+		mutex:    &sync.Mutex{},
+		changed:  make(chan bool),
+		frames:   make(map[uint64]*natsStreamFrame),
+	}
+}
+
+func (broker *NATS) SubscribeStream(channel string, channelHandler service.ChannelHandler) error {
+	// Queue subscriptions that are made before connecting to the server.
+	if broker.natsConn == nil || broker.service == nil {
+		broker.queuedStreams[channel] = channelHandler
+		return nil
+	}
+
+	subscription, err := broker.natsConn.QueueSubscribe(channel, broker.service.Config.Name, func(msg *nats.Msg) {
+		if msg.Header.Get(streamHeaderFrame) != streamFrameOpen || msg.Reply == "" {
+			return
+		}
+
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+			return
+		}
+
+		stream := newNATSStream(broker)
+		stream.peer = msg.Reply
+		stream.peerWindow = parseStreamWindow(msg.Header)
+		if err := stream.listen(); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to accept stream")
+			return
+		}
+
+		// Accept the stream by telling the peer where to send frames to.
+		accept := nats.NewMsg(stream.peer)
+		accept.Reply = stream.inbox
+		accept.Header.Set(streamHeaderFrame, streamFrameAccept)
+		accept.Header.Set(streamHeaderWindow, strconv.Itoa(broker.options.StreamWindow))
+		if err := broker.natsConn.PublishMsg(accept); err != nil {
+			broker.service.Logger.Error().Err(err).Msg("Failed to accept stream")
+			stream.close()
+			return
+		}
+
+		// Streams are long-lived and must therefore not block the subscription.
+		go func() {
+			if handlerErr := channelHandler(&service.Context{
+				Service:    broker.service,
+				Cloudevent: &event,
+				Channel:    msg.Subject,
+				Stream:     stream,
+			}); handlerErr != nil {
+				broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+				stream.Cancel()
+				return
+			}
+
+			stream.CloseWrite()
+			stream.release()
+		}()
+	})
+	if err != nil {
+		return err
+	}
+
+	broker.mutex.Lock()
+	broker.activeSubscriptions[channel] = subscription
+	broker.mutex.Unlock()
+
+	register(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *NATS) OpenStream(ctx context.Context, endpoint string, data interface{}) (service.Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stream := newNATSStream(broker)
+	if err := stream.listen(); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(newEvent(ctx, broker.service, endpoint, data))
+	if err != nil {
+		stream.close()
+		return nil, err
+	}
+
+	open := nats.NewMsg(endpoint)
+	open.Reply = stream.inbox
+	open.Data = encoded
+	open.Header.Set(streamHeaderFrame, streamFrameOpen)
+	open.Header.Set(streamHeaderWindow, strconv.Itoa(broker.options.StreamWindow))
+	if err := broker.natsConn.PublishMsg(open); err != nil {
+		stream.close()
+		return nil, err
+	}
+
+	// Wait until a peer accepts the stream. A peer that accepts it after the
+	// opener gave up is notified by the server, as the inbox is unsubscribed.
+	select {
+	case err := <-stream.accepted:
+		if err != nil {
+			stream.close()
+			return nil, err
+		}
+		return stream, nil
+	case <-ctx.Done():
+		stream.close()
+		return nil, ctx.Err()
+	case <-time.After(broker.options.StreamTimeout):
+		stream.close()
+		return nil, service.ErrStreamTimeout
+	}
+}
+
+func (stream *natsStream) Read(p []byte) (int, error) {
+	for {
+		stream.mutex.Lock()
+		if stream.canceled {
+			stream.mutex.Unlock()
+			return 0, service.ErrStreamCanceled
+		}
+
+		// Fetch the next frame if the current one is fully read.
+		if len(stream.buffer) == 0 && !stream.eof {
+			if frame, ok := stream.frames[stream.read+1]; ok {
+				delete(stream.frames, stream.read+1)
+				stream.read += 1
+				stream.buffer = frame.data
+				stream.eof = frame.close
+
+				// Grant the peer a new credit for the consumed frame.
+				stream.send(streamFrameAck, stream.read, nil)
+			}
+		}
+
+		if len(stream.buffer) > 0 {
+			n := copy(p, stream.buffer)
+			stream.buffer = stream.buffer[n:]
+			stream.mutex.Unlock()
+			return n, nil
+		}
+
+		if stream.eof {
+			// Keep receiving acknowledgements until the writing side is closed.
+			if stream.writeClosed {
+				stream.cleanup()
+			}
+			stream.mutex.Unlock()
+			return 0, io.EOF
+		}
+
+		changed := stream.changed
+		stream.mutex.Unlock()
+
+		// Give up if the peer stopped sending frames, for
+		// example because it died without canceling the stream.
+		select {
+		case <-changed:
+		case <-time.After(stream.broker.options.StreamTimeout):
+			stream.Cancel()
+			return 0, service.ErrStreamTimeout
+		}
+	}
+}
+
+func (stream *natsStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		stream.mutex.Lock()
+		if stream.canceled {
+			stream.mutex.Unlock()
+			return written, service.ErrStreamCanceled
+		}
+		if stream.writeClosed {
+			stream.mutex.Unlock()
+			return written, service.ErrStreamClosed
+		}
+
+		// Wait for a credit if the window of the peer is exhausted.
+		if stream.sent-stream.acked >= stream.peerWindow {
+			changed := stream.changed
+			stream.mutex.Unlock()
+
+			select {
+			case <-changed:
+				continue
+			case <-time.After(stream.broker.options.StreamTimeout):
+				return written, service.ErrStreamTimeout
+			}
+		}
+
+		chunk := p[written:]
+		if len(chunk) > stream.broker.options.StreamChunkSize {
+			chunk = chunk[:stream.broker.options.StreamChunkSize]
+		}
+
+		stream.sent += 1
+		err := stream.send(streamFrameData, stream.sent, chunk)
+		stream.mutex.Unlock()
+		if err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+func (stream *natsStream) CloseWrite() error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.writeClosed || stream.canceled {
+		return nil
+	}
+	stream.writeClosed = true
+
+	// The close frame is numbered to be received after all data frames.
+	stream.sent += 1
+	err := stream.send(streamFrameClose, stream.sent, nil)
+
+	if stream.eof && len(stream.buffer) == 0 {
+		stream.cleanup()
+	}
+
+	return err
+}
+
+func (stream *natsStream) Cancel() error {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	if stream.canceled {
+		return nil
+	}
+	stream.canceled = true
+	err := stream.send(streamFrameCancel, 0, nil)
+	stream.cleanup()
+	stream.notify()
+
+	return err
+}
+
+// listen subscribes to the private inbox of the stream.
+func (stream *natsStream) listen() error {
+	subscription, err := stream.broker.natsConn.Subscribe(stream.inbox, stream.receive)
+	if err != nil {
+		return err
+	}
+	stream.subscription = subscription
+
+	return nil
+}
+
+// receive processes the frames sent by the peer.
+func (stream *natsStream) receive(msg *nats.Msg) {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	// Nobody received the opening or accepting frame of the stream.
+	if msg.Header.Get(natsHeaderStatus) == natsStatusNoResponders {
+		select {
+		case stream.accepted <- service.ErrNoResponders:
+		default:
+		}
+		stream.canceled = true
+		stream.cleanup()
+		stream.notify()
+		return
+	}
+
+	seq, _ := strconv.ParseUint(msg.Header.Get(streamHeaderSeq), 10, 64)
+	switch msg.Header.Get(streamHeaderFrame) {
+	case streamFrameAccept:
+		stream.peer = msg.Reply
+		stream.peerWindow = parseStreamWindow(msg.Header)
+		select {
+		case stream.accepted <- nil:
+		default:
+		}
+	case streamFrameData, streamFrameClose:
+		// Ignore duplicate frames.
+		if seq <= stream.read {
+			return
+		}
+
+		// Cancel the stream if the peer exceeds its window, as the frames
+		// would otherwise be buffered without limit. The close frame is
+		// sent without a credit and may therefore exceed it by one.
+		if seq > stream.read+uint64(stream.broker.options.StreamWindow)+1 {
+			stream.canceled = true
+			stream.send(streamFrameCancel, 0, nil)
+			stream.cleanup()
+			break
+		}
+
+		frame := &natsStreamFrame{
+			data:  msg.Data,
+			close: msg.Header.Get(streamHeaderFrame) == streamFrameClose,
+		}
+		stream.frames[seq] = frame
+		stream.drain()
+	case streamFrameAck:
+		if seq > stream.acked {
+			stream.acked = seq
+		}
+	case streamFrameCancel:
+		stream.canceled = true
+		stream.cleanup()
+	}
+
+	stream.notify()
+}
+
+// release discards all data that is not yet read, because
+// the channel handler that owns the stream has returned.
+func (stream *natsStream) release() {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	stream.discard = true
+	stream.buffer = nil
+	stream.drain()
+}
+
+// drain acknowledges received frames without reading them if the
+// stream was released. The mutex must be held.
+func (stream *natsStream) drain() {
+	if !stream.discard {
+		return
+	}
+
+	for frame, ok := stream.frames[stream.read+1]; ok; frame, ok = stream.frames[stream.read+1] {
+		delete(stream.frames, stream.read+1)
+		stream.read += 1
+		stream.eof = frame.close
+		stream.send(streamFrameAck, stream.read, nil)
+	}
+
+	if stream.eof && stream.writeClosed {
+		stream.cleanup()
+	}
+}
+
+// send publishes a frame to the peer. The mutex must be held.
+func (stream *natsStream) send(frame string, seq uint64, data []byte) error {
+	msg := nats.NewMsg(stream.peer)
+	msg.Data = data
+	msg.Header.Set(streamHeaderFrame, frame)
+	msg.Header.Set(streamHeaderSeq, strconv.FormatUint(seq, 10))
+
+	return stream.broker.natsConn.PublishMsg(msg)
+}
+
+// notify wakes up all blocked readers and writers. The mutex must be held.
+func (stream *natsStream) notify() {
+	close(stream.changed)
+	stream.changed = make(chan bool)
+}
+
+// cleanup unsubscribes from the private inbox. The mutex must be held.
+func (stream *natsStream) cleanup() {
+	if stream.subscription != nil {
+		stream.subscription.Unsubscribe()
+		stream.subscription = nil
+	}
+}
+
+// close releases the resources of a stream that was never established.
+func (stream *natsStream) close() {
+	stream.mutex.Lock()
+	stream.cleanup()
+	stream.mutex.Unlock()
+}
+
+// parseStreamWindow reads the window that was announced by the peer.
+func parseStreamWindow(header nats.Header) uint64 {
+	window, err := strconv.ParseUint(header.Get(streamHeaderWindow), 10, 64)
+	if err != nil || window == 0 {
+		return DefaultStreamWindow
+	}
+
+	return window
+}
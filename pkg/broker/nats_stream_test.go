@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// newNATSStreamService creates a service with a NATS broker that uses small
+// chunks and a small window to exercise the flow control of streams.
+func newNATSStreamService(t *testing.T, name string, uri string) *service.Service {
+	t.Helper()
+
+	svc := service.New(service.Config{Name: name})
+	svc.UseBroker(NewNATS(&NATSOptions{
+		URI:             uri,
+		StreamWindow:    2,
+		StreamChunkSize: 4,
+		StreamTimeout:   2 * time.Second,
+	}))
+
+	return svc
+}
+
+func TestNATSStreamFlowControl(t *testing.T) {
+	uri := startNATS(t)
+
+	// The handler only starts reading once the window of the opener is exhausted.
+	unblock := make(chan bool)
+	files := newNATSStreamService(t, "files", uri)
+	files.BrokerStream("files.echo", func(ctx *service.Context) error {
+		<-unblock
+		_, err := io.Copy(ctx.Stream, ctx.Stream)
+		return err
+	})
+	connect(t, files)
+
+	client := newNATSStreamService(t, "client", uri)
+	connect(t, client)
+
+	stream, err := client.Broker.OpenStream(context.Background(), "files.echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("The quick brown fox jumps over the lazy dog.")
+	written := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(data)
+		if err == nil {
+			err = stream.CloseWrite()
+		}
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		t.Fatalf("expected write to block, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	natsStream := stream.(*natsStream)
+	natsStream.mutex.Lock()
+	pending := natsStream.sent - natsStream.acked
+	natsStream.mutex.Unlock()
+	if pending != 2 {
+		t.Errorf("expected 2 pending chunks, got %d", pending)
+	}
+
+	close(unblock)
+
+	echo, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Errorf("expected echo %q, got %q", data, echo)
+	}
+}
+
+func TestNATSStreamCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		// handlerErr is returned by the handler, which cancels the stream.
+		handlerErr error
+	}{
+		{name: "canceled by opener"},
+		{name: "canceled by handler", handlerErr: errors.New("failed")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uri := startNATS(t)
+
+			read := make(chan error, 1)
+			files := newNATSStreamService(t, "files", uri)
+			files.BrokerStream("files.upload", func(ctx *service.Context) error {
+				if tt.handlerErr != nil {
+					return tt.handlerErr
+				}
+
+				_, err := io.ReadAll(ctx.Stream)
+				read <- err
+				return err
+			})
+			connect(t, files)
+
+			client := newNATSStreamService(t, "client", uri)
+			connect(t, client)
+
+			stream, err := client.Broker.OpenStream(context.Background(), "files.upload", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.handlerErr != nil {
+				if _, err := stream.Read(make([]byte, 1)); err != service.ErrStreamCanceled {
+					t.Errorf("expected error %v, got %v", service.ErrStreamCanceled, err)
+				}
+				return
+			}
+
+			if err := stream.Cancel(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-read:
+				if err != service.ErrStreamCanceled {
+					t.Errorf("expected error %v, got %v", service.ErrStreamCanceled, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected handler to observe cancellation")
+			}
+			if _, err := stream.Write([]byte("data")); err != service.ErrStreamCanceled {
+				t.Errorf("expected error %v, got %v", service.ErrStreamCanceled, err)
+			}
+		})
+	}
+}
+
+func TestNATSOpenStream(t *testing.T) {
+	uri := startNATS(t)
+
+	// The peer receives the opening frame, but never accepts the stream.
+	nc, err := nats.Connect(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if _, err := nc.Subscribe("files.stalled", func(msg *nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newNATSStreamService(t, "client", uri)
+	connect(t, client)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+		channel string
+		err     error
+	}{
+		{name: "no responders", ctx: context.Background(), channel: "files.missing", err: service.ErrNoResponders},
+		{name: "canceled context", ctx: canceled, channel: "files.stalled", err: context.Canceled},
+		{name: "context deadline", ctx: context.Background(), timeout: 100 * time.Millisecond, channel: "files.stalled", err: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			// The stream must not wait for the stream timeout.
+			start := time.Now()
+			if _, err := client.Broker.OpenStream(ctx, tt.channel, nil); err != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected to give up early, took %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// startNATS starts an embedded NATS server with JetStream, which replies
+// to the registrations of channels in place of the status service.
+func startNATS(t *testing.T) string {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("server not ready")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	reply := cloudevents.NewEvent()
+	reply.SetID("status")
+	reply.SetSource("status")
+	reply.SetType(service.EventTypeResponse)
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nc.Subscribe("channels.*", func(msg *nats.Msg) {
+		msg.Respond(encoded)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return ns.ClientURL()
+}
+
+func TestNATSPersistentChannel(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures is the number of deliveries that fail.
+		failures int32
+		err      error
+		status   int
+		attempts int32
+	}{
+		{name: "success after redelivery", failures: 1, attempts: 2},
+		{name: "failure of last delivery", failures: 2, err: errors.New("failed"), status: 500, attempts: 2},
+		{name: "client error", failures: 2, err: errs.InvalidData, status: 422, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each test uses its own server, as the stream retains rejected events.
+			svc := service.New(service.Config{Name: "mail"})
+			svc.UseBroker(NewNATS(&NATSOptions{URI: startNATS(t), RequestTimeout: 5 * time.Second}))
+
+			var attempts int32
+			svc.PersistentBrokerChannel(service.PersistentChannel{
+				Name:       "mails.create",
+				MaxDeliver: 2,
+				AckWait:    1 * time.Second,
+			}, func(ctx *service.Context) error {
+				if atomic.AddInt32(&attempts, 1) <= tt.failures {
+					if tt.err != nil {
+						return tt.err
+					}
+					return errors.New("failed")
+				}
+				return ctx.Reply("sent")
+			})
+
+			if err := svc.Broker.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer svc.Broker.Disconnect()
+
+			res, err := svc.Broker.Request("mails.create", "mail")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only the reply of the final outcome must be received.
+			var svcErr *errs.ServiceError
+			if err := res.Err(); errors.As(err, &svcErr) != (tt.status != 0) || (svcErr != nil && svcErr.Status != tt.status) {
+				t.Errorf("expected status %d, got %v", tt.status, err)
+			}
+			if attempts := atomic.LoadInt32(&attempts); attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		delivered uint64
+		ackWait   time.Duration
+		delay     time.Duration
+	}{
+		{delivered: 1, ackWait: 30 * time.Second, delay: 1 * time.Second},
+		{delivered: 2, ackWait: 30 * time.Second, delay: 2 * time.Second},
+		{delivered: 4, ackWait: 30 * time.Second, delay: 8 * time.Second},
+		{delivered: 10, ackWait: 30 * time.Second, delay: 30 * time.Second},
+		{delivered: 1, ackWait: 500 * time.Millisecond, delay: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if delay := retryDelay(tt.delivered, tt.ackWait); delay != tt.delay {
+			t.Errorf("expected delay %s for delivery %d, got %s", tt.delay, tt.delivered, delay)
+		}
+	}
+}
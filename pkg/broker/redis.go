@@ -0,0 +1,438 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the implementation of the Broker interface
+// for the Redis Pub/Sub event broker (https://redis.io/topics/pubsub).
+
+const (
+	DefaultRedisClaimTTL     = 1 * time.Minute
+	DefaultRedisPingInterval = 5 * time.Second
+)
+
+type RedisOptions struct {
+	URI            string
+	RedisOptions   *redis.Options
+	RequestTimeout time.Duration
+	// ClaimTTL is the duration for which an instance of a service
+	// claims an event to emulate the queue group semantics of NATS.
+	ClaimTTL time.Duration
+	// PingInterval limits how often the connection is checked
+	// when reporting whether the broker is connected.
+	PingInterval time.Duration
+}
+
+type Redis struct {
+	service             *service.Service
+	options             *RedisOptions
+	client              *redis.Client
+	activeSubscriptions map[string]*redis.PubSub
+	queuedSubscriptions map[string]service.ChannelHandler
+	mutex               *sync.Mutex
+
+	// The result of the last ping is reused until the ping interval passed.
+	pingedAt    time.Time
+	pingErr     error
+	pingedMutex *sync.Mutex
+}
+
+// redisSubscription is a subscription that was created via `Watch()`.
+type redisSubscription struct {
+	pubsub *redis.PubSub
+}
+
+func (sub *redisSubscription) Unsubscribe() error {
+	return sub.pubsub.Close()
+}
+
+// redisEnvelope wraps a cloud event, because Redis Pub/Sub does not
+// natively support a reply channel. The delivery ID is unique for every
+// publication, while retried requests reuse the ID of the event.
+type redisEnvelope struct {
+	Reply    string          `json:"reply,omitempty"`
+	Delivery string          `json:"delivery"`
+	Event    json.RawMessage `json:"event"`
+}
+
+func NewRedis(opts *RedisOptions) service.Broker {
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = 1000 * time.Millisecond
+	}
+
+	if opts.ClaimTTL == 0 {
+		opts.ClaimTTL = DefaultRedisClaimTTL
+	}
+
+	if opts.PingInterval == 0 {
+		opts.PingInterval = DefaultRedisPingInterval
+	}
+
+	return &Redis{
+		options:             opts,
+		activeSubscriptions: make(map[string]*redis.PubSub),
+		queuedSubscriptions: make(map[string]service.ChannelHandler),
+		mutex:               &sync.Mutex{},
+		pingedMutex:         &sync.Mutex{},
+	}
+}
+
+func (broker *Redis) Bind(svc *service.Service) {
+	broker.service = svc
+}
+
+func (broker *Redis) Subscribe(channel string, channelHandler service.ChannelHandler) error {
+	// Queue subscriptions that are made before connecting to the server.
+	if broker.client == nil || broker.service == nil {
+		broker.queuedSubscriptions[channel] = channelHandler
+		return nil
+	}
+
+	ctx := context.Background()
+	pubsub := broker.client.PSubscribe(ctx, redisPattern(channel))
+
+	// Wait for the subscription to be confirmed by the server.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			// Redis patterns are less strict than channel patterns,
+			// because the `*` also matches multiple hierarchy levels.
+			if !MatchChannel(channel, msg.Channel) {
+				continue
+			}
+
+			event, delivery, err := broker.decode(msg.Payload)
+			if err != nil {
+				broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+				continue
+			}
+
+			// Ensure that only one instance of this service handles the delivery.
+			if !broker.claim(channel, delivery) {
+				continue
+			}
+
+			// Invoke the channel handler with the user-defined business logic.
+			if handlerErr := channelHandler(&service.Context{
+				Service:    broker.service,
+				Cloudevent: event,
+				Channel:    msg.Channel,
+			}); handlerErr != nil {
+				broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+				continue
+			}
+		}
+	}()
+
+	broker.mutex.Lock()
+	broker.activeSubscriptions[channel] = pubsub
+	broker.mutex.Unlock()
+
+	register(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *Redis) Unsubscribe(channel string) error {
+	broker.mutex.Lock()
+	pubsub := broker.activeSubscriptions[channel]
+	delete(broker.activeSubscriptions, channel)
+	broker.mutex.Unlock()
+
+	// Notify developer that there is a logic error
+	// when unsubscribing without prior subscription.
+	if pubsub == nil {
+		return service.ErrIllegalUnsubscribe
+	}
+
+	if err := pubsub.Close(); err != nil {
+		return err
+	}
+
+	unregister(broker, broker.service, channel)
+
+	return nil
+}
+
+func (broker *Redis) Watch(channel string, channelHandler service.ChannelHandler) (service.Subscription, error) {
+	if broker.client == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	ctx := context.Background()
+	pubsub := broker.client.PSubscribe(ctx, redisPattern(channel))
+
+	// Wait for the subscription to be confirmed by the server.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			if !MatchChannel(channel, msg.Channel) {
+				continue
+			}
+
+			event, _, err := broker.decode(msg.Payload)
+			if err != nil {
+				broker.service.Logger.Error().Err(err).Msg("Failed to decode cloud event")
+				continue
+			}
+
+			if handlerErr := channelHandler(&service.Context{
+				Service:    broker.service,
+				Cloudevent: event,
+				Channel:    msg.Channel,
+			}); handlerErr != nil {
+				broker.service.Logger.Error().Err(handlerErr).Msg("Failed to run channel handler")
+			}
+		}
+	}()
+
+	return &redisSubscription{pubsub: pubsub}, nil
+}
+
+func (broker *Redis) Publish(endpoint string, data interface{}) error {
+	return broker.PublishContext(context.Background(), endpoint, data)
+}
+
+func (broker *Redis) PublishContext(ctx context.Context, endpoint string, data interface{}) error {
+	return broker.publish(ctx, endpoint, "", newEvent(ctx, broker.service, endpoint, data))
+}
+
+func (broker *Redis) PublishEvent(endpoint string, event *cloudevents.Event) error {
+	return broker.publish(context.Background(), endpoint, "", event)
+}
+
+func (broker *Redis) Request(endpoint string, data interface{}) (*service.Context, error) {
+	return broker.RequestContext(context.Background(), endpoint, data)
+}
+
+func (broker *Redis) RequestContext(ctx context.Context, endpoint string, data interface{}, opts ...service.RequestOption) (*service.Context, error) {
+	event := newEvent(ctx, broker.service, endpoint, data)
+
+	return request(ctx, broker.options.RequestTimeout, func(ctx context.Context) (*service.Context, error) {
+		return broker.request(ctx, endpoint, event)
+	}, opts...)
+}
+
+func (broker *Redis) Persist(channel service.PersistentChannel) error {
+	return service.ErrPersistenceUnsupported
+}
+
+func (broker *Redis) SubscribeStream(channel string, channelHandler service.ChannelHandler) error {
+	return service.ErrStreamsUnsupported
+}
+
+func (broker *Redis) OpenStream(ctx context.Context, endpoint string, data interface{}) (service.Stream, error) {
+	return nil, service.ErrStreamsUnsupported
+}
+
+func (broker *Redis) Connect() error {
+	// Ensure that URI is provided.
+	if broker.options.URI == "" && broker.options.RedisOptions == nil {
+		broker.service.Logger.Fatal().Msgf("Configuration missing: BROKER_URI")
+	}
+
+	// Parse URI if no client options were provided.
+	redisOptions := broker.options.RedisOptions
+	if redisOptions == nil {
+		parsed, err := redis.ParseURL(broker.options.URI)
+		if err != nil {
+			broker.service.Logger.Fatal().Msgf("Configuration invalid: BROKER_URI")
+		}
+		redisOptions = parsed
+	}
+
+	// Only log the address to avoid leaking the credentials.
+	redacted := &url.URL{Scheme: "redis", Host: redisOptions.Addr}
+	redactedBrokerURI := redacted.String()
+
+	// Connect to Redis broker.
+	client := redis.NewClient(redisOptions)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return err
+	}
+	broker.client = client
+
+	// Subscribe to queued subscriptions.
+	for channel := range broker.queuedSubscriptions {
+		if err := broker.Subscribe(channel, broker.queuedSubscriptions[channel]); err != nil {
+			return err
+		}
+
+		delete(broker.queuedSubscriptions, channel)
+	}
+
+	// Log successful connection.
+	broker.service.Logger.Info().Msgf("Connected to broker: %s", redactedBrokerURI)
+
+	return nil
+}
+
+func (broker *Redis) Disconnect() error {
+	broker.mutex.Lock()
+	channels := make([]string, 0, len(broker.activeSubscriptions))
+	for channel := range broker.activeSubscriptions {
+		channels = append(channels, channel)
+	}
+	broker.mutex.Unlock()
+
+	// Close all subscriptions manually to ensure that the channels are unregistered.
+	for _, channel := range channels {
+		if err := broker.Unsubscribe(channel); err != nil {
+			return err
+		}
+	}
+
+	return broker.client.Close()
+}
+
+func (broker *Redis) Connected() bool {
+	if broker.client == nil {
+		return false
+	}
+
+	// Only ping the server once per interval, as the connection
+	// may be checked on every request, for example by probes.
+	broker.pingedMutex.Lock()
+	defer broker.pingedMutex.Unlock()
+	if time.Since(broker.pingedAt) >= broker.options.PingInterval {
+		broker.pingErr = broker.client.Ping(context.Background()).Err()
+		broker.pingedAt = time.Now()
+	}
+
+	return broker.pingErr == nil
+}
+
+// request sends the event to a private reply channel and waits for the reply.
+func (broker *Redis) request(ctx context.Context, endpoint string, event *cloudevents.Event) (*service.Context, error) {
+	if broker.client == nil {
+		return nil, service.ErrBrokerDisconnected
+	}
+
+	// Subscribe to a private reply channel before sending the request.
+	inbox := InboxPrefix + ChannelSeparator + uuid.NewString()
+	pubsub := broker.client.Subscribe(ctx, inbox)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, service.ErrRequestTimeout
+		}
+		return nil, err
+	}
+
+	if err := broker.publish(ctx, endpoint, inbox, event); err != nil {
+		return nil, err
+	}
+
+	var msg *redis.Message
+	select {
+	case msg = <-pubsub.Channel():
+	case <-ctx.Done():
+		return nil, service.ErrRequestTimeout
+	}
+
+	envelope := new(redisEnvelope)
+	if err := json.Unmarshal([]byte(msg.Payload), envelope); err != nil {
+		return nil, err
+	}
+
+	res := new(cloudevents.Event)
+	if err := json.Unmarshal(envelope.Event, res); err != nil {
+		return nil, err
+	}
+
+	return &service.Context{
+		Service:    broker.service,
+		Cloudevent: res,
+	}, nil
+}
+
+// publish wraps the cloud event in an envelope and publishes it.
+func (broker *Redis) publish(ctx context.Context, endpoint string, reply string, event *cloudevents.Event) error {
+	if broker.client == nil {
+		return service.ErrBrokerDisconnected
+	}
+
+	encodedEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(redisEnvelope{
+		Reply:    reply,
+		Delivery: uuid.NewString(),
+		Event:    encodedEvent,
+	})
+	if err != nil {
+		return err
+	}
+
+	return broker.client.Publish(ctx, endpoint, encoded).Err()
+}
+
+// decode unwraps the cloud event and the delivery ID from an envelope
+// and stores the reply channel in the event to allow replying.
+func (broker *Redis) decode(payload string) (*cloudevents.Event, string, error) {
+	envelope := new(redisEnvelope)
+	if err := json.Unmarshal([]byte(payload), envelope); err != nil {
+		return nil, "", err
+	}
+
+	event := cloudevents.NewEvent()
+	if err := json.Unmarshal(envelope.Event, &event); err != nil {
+		return nil, "", err
+	}
+
+	if envelope.Reply != "" {
+		event.SetExtension(service.ExtensionReply, envelope.Reply)
+	}
+
+	return &event, envelope.Delivery, nil
+}
+
+// claim emulates NATS queue groups by atomically claiming a delivery
+// for the service. Deliveries are claimed rather than events, because
+// retried requests reuse the event, which must then be handled again.
+func (broker *Redis) claim(channel string, delivery string) bool {
+	key := strings.Join([]string{"claims", broker.service.Config.Name, channel, delivery}, ":")
+	claimed, err := broker.client.SetNX(context.Background(), key, true, broker.options.ClaimTTL).Result()
+	if err != nil {
+		broker.service.Logger.Warn().Err(err).Msg("Failed to claim event")
+		return false
+	}
+
+	return claimed
+}
+
+// redisPattern translates a channel into a Redis glob-style pattern.
+// Both wildcards are translated to `*`, which in Redis matches any
+// number of characters including the channel separator.
+func redisPattern(channel string) string {
+	tokens := strings.Split(channel, ChannelSeparator)
+	for i, token := range tokens {
+		if token == ChannelWildcardMulti {
+			tokens[i] = "*"
+		}
+	}
+
+	return strings.Join(tokens, ChannelSeparator)
+}
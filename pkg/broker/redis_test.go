@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// startRedis starts an in-memory Redis server and a status service, which
+// replies to the registrations of channels. It returns the URI of the server.
+func startRedis(t *testing.T) (*miniredis.Miniredis, string) {
+	t.Helper()
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	uri := "redis://" + server.Addr()
+
+	status := service.New(service.Config{Name: "status"})
+	status.UseBroker(NewRedis(&RedisOptions{URI: uri, RequestTimeout: 50 * time.Millisecond}))
+	if err := status.Broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { status.Broker.Disconnect() })
+
+	// The channels are subscribed after connecting to ensure that
+	// the channels are registered in order at the status service.
+	for _, channel := range []string{ChannelSubscribe, ChannelUnsubscribe} {
+		status.BrokerChannel(channel, func(ctx *service.Context) error {
+			return ctx.Reply(nil)
+		})
+	}
+
+	return server, uri
+}
+
+// newRedisService creates a service with a Redis broker, whose
+// channels are registered by the caller before connecting.
+func newRedisService(t *testing.T, name string, uri string) *service.Service {
+	t.Helper()
+
+	svc := service.New(service.Config{Name: name})
+	svc.UseBroker(NewRedis(&RedisOptions{URI: uri, RequestTimeout: 200 * time.Millisecond}))
+
+	return svc
+}
+
+func connect(t *testing.T, svc *service.Service) {
+	t.Helper()
+
+	if err := svc.Broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { svc.Broker.Disconnect() })
+}
+
+func TestRedisRequest(t *testing.T) {
+	_, uri := startRedis(t)
+
+	pets := newRedisService(t, "pets", uri)
+	pets.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	pets.BrokerChannel("v1.*.find", func(ctx *service.Context) error {
+		return ctx.Reply(ctx.Channel)
+	})
+	connect(t, pets)
+
+	client := newRedisService(t, "client", uri)
+	connect(t, client)
+
+	tests := []struct {
+		name    string
+		channel string
+		err     error
+	}{
+		{name: "concrete channel", channel: "v1.pets.read"},
+		{name: "single-level wildcard", channel: "v1.toys.find"},
+		{name: "wildcard too deep", channel: "v1.pets.toys.find", err: service.ErrRequestTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client.Broker.Request(tt.channel, nil)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var channel string
+			if err := res.Cloudevent.DataAs(&channel); err != nil {
+				t.Fatal(err)
+			}
+			if channel != tt.channel {
+				t.Errorf("expected channel %s, got %s", tt.channel, channel)
+			}
+		})
+	}
+}
+
+func TestRedisQueueGroup(t *testing.T) {
+	_, uri := startRedis(t)
+
+	var handled int32
+	for i := 0; i < 2; i++ {
+		instance := newRedisService(t, "mail", uri)
+		instance.BrokerChannel("mails.create", func(ctx *service.Context) error {
+			atomic.AddInt32(&handled, 1)
+			return nil
+		})
+		connect(t, instance)
+	}
+
+	client := newRedisService(t, "client", uri)
+	connect(t, client)
+
+	// A retried event is delivered again and must therefore be handled again.
+	event := newEvent(context.Background(), client, "mails.create", "mail")
+	for i := 0; i < 2; i++ {
+		if err := client.Broker.PublishEvent("mails.create", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&handled) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Wait for duplicate deliveries to the other instance.
+	time.Sleep(100 * time.Millisecond)
+
+	if handled := atomic.LoadInt32(&handled); handled != 2 {
+		t.Errorf("expected 2 handled deliveries, got %d", handled)
+	}
+}
+
+func TestRedisUnsubscribe(t *testing.T) {
+	_, uri := startRedis(t)
+
+	svc := newRedisService(t, "pets", uri)
+	svc.BrokerChannel("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply(nil)
+	})
+	connect(t, svc)
+
+	if err := svc.Broker.Unsubscribe("v1.pets.read"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Broker.Unsubscribe("v1.pets.read"); err != service.ErrIllegalUnsubscribe {
+		t.Errorf("expected error %v, got %v", service.ErrIllegalUnsubscribe, err)
+	}
+	if _, err := svc.Broker.Request("v1.pets.read", nil); err != service.ErrRequestTimeout {
+		t.Errorf("expected error %v, got %v", service.ErrRequestTimeout, err)
+	}
+}
+
+func TestRedisConnected(t *testing.T) {
+	server, uri := startRedis(t)
+
+	broker := NewRedis(&RedisOptions{URI: uri}).(*Redis)
+	broker.Bind(service.New(service.Config{Name: "pets"}))
+	if err := broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer broker.client.Close()
+
+	if !broker.Connected() {
+		t.Fatal("expected broker to be connected")
+	}
+
+	// The result of the ping is reused within the ping interval.
+	server.Close()
+	if !broker.Connected() {
+		t.Error("expected cached connection state")
+	}
+
+	broker.pingedMutex.Lock()
+	broker.pingedAt = time.Now().Add(-2 * broker.options.PingInterval)
+	broker.pingedMutex.Unlock()
+	if broker.Connected() {
+		t.Error("expected broker to be disconnected")
+	}
+}
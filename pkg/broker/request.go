@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+var (
+	// DefaultRegisterRetryPolicy is used to register channels at the
+	// status service, which might be started after the service.
+	DefaultRegisterRetryPolicy = &service.RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	// DefaultUnregisterRetryPolicy is used to unregister channels at
+	// the status service, which usually happens during a shutdown.
+	DefaultUnregisterRetryPolicy = &service.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     1 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+)
+
+// requestAttempt sends a request and waits for the reply until the context is done.
+type requestAttempt func(context.Context) (*service.Context, error)
+
+// request performs attempts until a reply is received, the retry policy is
+// exhausted or the context is done. Brokers should send the same event in
+// all attempts, which allows channel handlers to detect duplicates.
+func request(ctx context.Context, timeout time.Duration, attempt requestAttempt, opts ...service.RequestOption) (*service.Context, error) {
+	options := &service.RequestOptions{
+		Timeout: timeout,
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(options)
+		}
+	}
+
+	for attempts := 1; ; attempts++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, options.Timeout)
+		res, err := attempt(attemptCtx)
+		cancel()
+
+		// Only requests that were not received are retried, as all other errors are permanent.
+		retryable := err == service.ErrRequestTimeout || err == service.ErrNoResponders
+		if !retryable || attempts >= options.Retry.Attempts() {
+			return res, err
+		}
+
+		select {
+		case <-time.After(options.Retry.Backoff(attempts)):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// register attempts to register a subscription at the status service.
+func register(broker service.Broker, svc *service.Service, channel string) {
+	channelInfo := service.Channel{Name: channel}
+	if _, err := broker.RequestContext(context.Background(), ChannelSubscribe, channelInfo, service.WithRetry(DefaultRegisterRetryPolicy)); err != nil {
+		svc.Logger.Warn().Err(err).Msgf("Failed to register channel: %s", channel)
+	}
+}
+
+// unregister attempts to unregister a subscription at the status service.
+func unregister(broker service.Broker, svc *service.Service, channel string) {
+	channelInfo := service.Channel{Name: channel}
+	if _, err := broker.RequestContext(context.Background(), ChannelUnsubscribe, channelInfo, service.WithRetry(DefaultUnregisterRetryPolicy)); err != nil {
+		svc.Logger.Warn().Err(err).Msgf("Failed to unregister channel: %s", channel)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// This file contains the command line flags that all commands support:
+//
+//   --config <file>  loads the YAML or TOML file, which may also be
+//                    configured via the `CONFIG_FILE` variable.
+//   --print-config   prints the configuration with redacted secrets
+//                    and exits.
+
+const (
+	// EnvConfigFile is the variable of the configuration file.
+	EnvConfigFile = "CONFIG_FILE"
+)
+
+// MustLoad loads the configuration of a command from the sources that are
+// configured via the command line flags. The process exits if the
+// configuration is invalid or if it was asked to print the configuration.
+func MustLoad(target interface{}) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	file := flags.String("config", os.Getenv(EnvConfigFile), "path of the YAML or TOML configuration file")
+	printConfig := flags.Bool("print-config", false, "print the configuration and exit")
+	flags.Parse(os.Args[1:])
+
+	// The logger of the service is configured via the configuration.
+	logger := zerolog.New(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: time.RFC3339,
+	}).With().Timestamp().Logger()
+
+	err := Load(target, File(*file))
+	if *printConfig {
+		if err := Print(os.Stdout, target); err != nil {
+			logger.Fatal().Err(err).Msg("Failed to print configuration")
+		}
+	}
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+	if *printConfig {
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,335 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// This file contains the loader of typed configuration structs. Each
+// field is tagged with the environment variable it is loaded from:
+//
+//   type Config struct {
+//     BrokerURI      string        `env:"BROKER_URI" required:"true"`
+//     RequestTimeout time.Duration `env:"BROKER_REQUEST_TIMEOUT" default:"1s"`
+//     APIKey         string        `env:"SENDGRID_API_KEY" secret:"true"`
+//   }
+//
+// Values are looked up in the following order, whereby the first source
+// that contains a key wins: environment variables, the `.env` file, the
+// YAML or TOML configuration file and finally the default of the field.
+// Keys of configuration files are matched case-insensitively, whereby
+// nested keys are joined with an underscore, such that `broker.uri`
+// corresponds to `BROKER_URI`. Lists are joined with a comma.
+
+const (
+	// DefaultEnvFile is the dotenv file that is loaded if it exists.
+	DefaultEnvFile = ".env"
+
+	// Redacted replaces the values of secrets when printing.
+	Redacted = "[REDACTED]"
+)
+
+var (
+	ErrInvalidTarget     = errors.New("config: target must be a pointer to a struct")
+	ErrUnknownFileFormat = errors.New("config: unknown file format")
+	ErrUnsupportedField  = errors.New("config: unsupported field type")
+)
+
+// ValidationError lists all keys that are missing or invalid.
+type ValidationError struct {
+	Missing []string
+	Invalid []string
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0)
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing keys: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		problems = append(problems, "invalid keys: "+strings.Join(e.Invalid, ", "))
+	}
+
+	return "config: " + strings.Join(problems, "; ")
+}
+
+// Options contains the sources of the configuration.
+type Options struct {
+	// File is the path of a YAML or TOML file. It is optional.
+	File string
+	// EnvFile is the path of a dotenv file, which is ignored if
+	// it does not exist. Defaults to `.env`.
+	EnvFile string
+}
+
+// Option describes the function signature of options.
+type Option func(*Options)
+
+// File configures the YAML or TOML file of the configuration.
+func File(path string) Option {
+	return func(o *Options) {
+		o.File = path
+	}
+}
+
+// EnvFile configures the dotenv file of the configuration.
+func EnvFile(path string) Option {
+	return func(o *Options) {
+		o.EnvFile = path
+	}
+}
+
+// field is a configurable field of a configuration struct.
+type field struct {
+	value reflect.Value
+	key   string
+	tag   reflect.StructTag
+}
+
+// Load populates the struct that the target points to. If keys are
+// missing or invalid, a *ValidationError is returned that lists all of them.
+func Load(target interface{}, options ...Option) error {
+	opts := &Options{EnvFile: DefaultEnvFile}
+	for _, option := range options {
+		option(opts)
+	}
+
+	fields, err := fieldsOf(target)
+	if err != nil {
+		return err
+	}
+
+	// Sources with a higher precedence overwrite the values of previous ones.
+	values := make(map[string]string)
+	if opts.File != "" {
+		if err := readFile(opts.File, values); err != nil {
+			return err
+		}
+	}
+	if opts.EnvFile != "" {
+		env, err := godotenv.Read(opts.EnvFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for key, value := range env {
+			values[key] = value
+		}
+	}
+
+	validationErr := &ValidationError{}
+	for _, f := range fields {
+		// Empty values are treated like missing ones.
+		value := os.Getenv(f.key)
+		if value == "" {
+			value = values[f.key]
+		}
+		if value == "" {
+			value = f.tag.Get("default")
+		}
+		if value == "" {
+			if f.tag.Get("required") == "true" {
+				validationErr.Missing = append(validationErr.Missing, f.key)
+			}
+			continue
+		}
+
+		if err := set(f.value, value); err != nil {
+			if err == ErrUnsupportedField {
+				return fmt.Errorf("%w: %s", err, f.key)
+			}
+			validationErr.Invalid = append(validationErr.Invalid, f.key)
+		}
+	}
+
+	if len(validationErr.Missing) > 0 || len(validationErr.Invalid) > 0 {
+		return validationErr
+	}
+
+	return nil
+}
+
+// Print writes the configuration as environment variables. The values of
+// secrets are redacted, as are the credentials of URIs.
+func Print(w io.Writer, target interface{}) error {
+	fields, err := fieldsOf(target)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		value := format(f.value)
+		if value != "" && f.tag.Get("secret") == "true" {
+			value = Redacted
+		}
+		fmt.Fprintf(w, "%s=%s\n", f.key, redactURI(value))
+	}
+
+	return nil
+}
+
+// fieldsOf returns the tagged fields of the struct that the target
+// points to, including the fields of embedded and nested structs.
+func fieldsOf(target interface{}) ([]field, error) {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return nil, ErrInvalidTarget
+	}
+
+	return structFields(value.Elem()), nil
+}
+
+func structFields(value reflect.Value) []field {
+	fields := make([]field, 0)
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		if structField.PkgPath != "" && !structField.Anonymous {
+			continue
+		}
+
+		key, ok := structField.Tag.Lookup("env")
+		if !ok {
+			if structField.Type.Kind() == reflect.Struct {
+				fields = append(fields, structFields(value.Field(i))...)
+			}
+			continue
+		}
+
+		fields = append(fields, field{
+			value: value.Field(i),
+			key:   key,
+			tag:   structField.Tag,
+		})
+	}
+
+	return fields
+}
+
+// set parses the value into the field.
+func set(value reflect.Value, raw string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return ErrUnsupportedField
+		}
+		items := strings.Split(raw, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return ErrUnsupportedField
+	}
+
+	return nil
+}
+
+// format returns the value of the field in the format that it is loaded from.
+func format(value reflect.Value) string {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(value.Int()).String()
+	}
+	if value.Kind() == reflect.Slice {
+		return strings.Join(value.Interface().([]string), ",")
+	}
+
+	return fmt.Sprint(value.Interface())
+}
+
+// redactURI removes the credentials of URIs, such as broker URIs.
+func redactURI(value string) string {
+	uri, err := url.Parse(value)
+	if err != nil || uri.User == nil {
+		return value
+	}
+	uri.User = nil
+
+	return uri.String()
+}
+
+// readFile reads the YAML or TOML file into the values.
+func readFile(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	content := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &content)
+	case ".toml":
+		err = toml.Unmarshal(data, &content)
+	default:
+		return ErrUnknownFileFormat
+	}
+	if err != nil {
+		return err
+	}
+
+	flatten("", content, values)
+	return nil
+}
+
+// flatten joins nested keys with an underscore.
+func flatten(prefix string, content map[string]interface{}, values map[string]string) {
+	for key, value := range content {
+		key = strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(key, v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
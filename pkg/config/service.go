@@ -0,0 +1,128 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the configuration that is shared by the commands,
+// such that all services are configured via the same keys and defaults.
+
+// Service contains the configuration of the service framework.
+type Service struct {
+	AdminPort     string        `env:"ADMIN_PORT"`
+	GracePeriod   time.Duration `env:"GRACE_PERIOD" default:"15s"`
+	TraceExporter string        `env:"TRACE_EXPORTER"`
+	LogFormat     string        `env:"LOG_FORMAT" default:"console"`
+	LogLevel      string        `env:"LOG_LEVEL" default:"info"`
+	LogSampling   uint32        `env:"LOG_SAMPLING"`
+}
+
+// ServiceConfig returns the configuration of the service.
+func (c *Service) ServiceConfig(name string, version string) service.Config {
+	return service.Config{
+		Name:          name,
+		Version:       version,
+		GracePeriod:   c.GracePeriod,
+		AdminPort:     c.AdminPort,
+		TraceExporter: c.TraceExporter,
+		LogFormat:     c.LogFormat,
+		LogLevel:      c.LogLevel,
+		LogSampling:   c.LogSampling,
+	}
+}
+
+// Broker contains the configuration of the NATS broker.
+type Broker struct {
+	BrokerURI      string        `env:"BROKER_URI" required:"true"`
+	RequestTimeout time.Duration `env:"BROKER_REQUEST_TIMEOUT" default:"1s"`
+}
+
+// NATSOptions returns the options of the NATS broker.
+func (c *Broker) NATSOptions() *broker.NATSOptions {
+	return &broker.NATSOptions{
+		URI:            c.BrokerURI,
+		RequestTimeout: c.RequestTimeout,
+	}
+}
+
+const (
+	// CredentialsSeparator separates the entries of the authorized
+	// credentials. Commas can not be used, as they are part of argon2 hashes.
+	CredentialsSeparator = ";"
+)
+
+// Auth contains the configuration of the authentication and
+// authorization of the gateways.
+type Auth struct {
+	JWTIssuer   string `env:"JWT_ISSUER"`
+	JWTAudience string `env:"JWT_AUDIENCE"`
+	JWTSecret   string `env:"JWT_SECRET" secret:"true"`
+	JWKSURL     string `env:"JWKS_URL"`
+	JWKSFile    string `env:"JWKS_FILE"`
+
+	CredentialsFile string `env:"CREDENTIALS_FILE"`
+	// AuthorizedCredentials is a list of users and their password
+	// hashes, which are separated by the CredentialsSeparator.
+	AuthorizedCredentials string `env:"AUTHORIZED_CREDENTIALS" secret:"true"`
+	PolicyFile            string `env:"POLICY_FILE"`
+	APIKeysFile           string `env:"API_KEYS_FILE"`
+}
+
+// JWTOptions returns the options of the token validation or nil
+// if neither a secret nor a key set is configured.
+func (c *Auth) JWTOptions() *auth.JWTOptions {
+	if c.JWTSecret == "" && c.JWKSURL == "" && c.JWKSFile == "" {
+		return nil
+	}
+
+	return &auth.JWTOptions{
+		Issuer:   c.JWTIssuer,
+		Audience: c.JWTAudience,
+		Secret:   []byte(c.JWTSecret),
+		JWKSURL:  c.JWKSURL,
+		JWKSFile: c.JWKSFile,
+	}
+}
+
+// Load loads the credentials, the policy and the API keys and configures
+// the token validation. The credentials are loaded from a file or, if no
+// file is configured, from the authorized credentials. The policy is nil
+// if no file is configured, in which case all authenticated users may
+// access all channels.
+func (c *Auth) Load() (*auth.Authenticator, *auth.Policy, error) {
+	credentials, err := auth.NewCredentials(&auth.CredentialsOptions{
+		File: c.CredentialsFile,
+		Data: strings.ReplaceAll(c.AuthorizedCredentials, CredentialsSeparator, "\n"),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	authenticator := &auth.Authenticator{Credentials: credentials}
+	if jwtOptions := c.JWTOptions(); jwtOptions != nil {
+		authenticator.JWT = auth.NewJWT(jwtOptions)
+	}
+
+	if c.APIKeysFile != "" {
+		authenticator.APIKeys, err = auth.LoadAPIKeys(c.APIKeysFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+	}
+
+	var policy *auth.Policy
+	if c.PolicyFile != "" {
+		policy, err = auth.LoadPolicy(c.PolicyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load policy: %w", err)
+		}
+	}
+
+	return authenticator, policy, nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthLoad(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The parameters of argon2 hashes are separated by commas.
+	salt := []byte("saltsaltsaltsalt")
+	argon2Hash := fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("argon2-pw"), salt, 1, 64, 1, 32)))
+
+	cfg := &Auth{
+		AuthorizedCredentials: strings.Join([]string{
+			"alice:" + string(bcryptHash),
+			"bob:" + argon2Hash,
+		}, CredentialsSeparator),
+	}
+
+	authenticator, policy, err := cfg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != nil || authenticator.JWT != nil || authenticator.APIKeys != nil {
+		t.Errorf("expected only credentials to be configured")
+	}
+
+	tests := []struct {
+		user     string
+		password string
+	}{
+		{user: "alice", password: "bcrypt-pw"},
+		{user: "bob", password: "argon2-pw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			if err := authenticator.Credentials.Verify(tt.user, tt.password); err != nil {
+				t.Errorf("expected credentials to be valid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthLoadPlaintext(t *testing.T) {
+	// Plaintext passwords were accepted before hashes were required.
+	cfg := &Auth{AuthorizedCredentials: "alice:password"}
+
+	if _, _, err := cfg.Load(); err == nil {
+		t.Fatal("expected plaintext password to be rejected")
+	}
+}
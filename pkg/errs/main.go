@@ -9,6 +9,9 @@ var (
 	MissingCredentials = NewServiceError(401, "Missing Credentials")
 	InvalidCredentials = NewServiceError(403, "Invalid Credentials")
 	InvalidEndpoint    = NewServiceError(404, "Invalid Endpoint")
+	InvalidData        = NewServiceError(422, "Invalid Data")
+	QuotaExceeded      = NewServiceError(429, "Quota Exceeded")
+	RateLimitExceeded  = NewServiceError(429, "Rate Limit Exceeded")
 	UnexpectedError    = NewServiceError(500, "Unexpected Error")
 	InvalidService     = NewServiceError(503, "Invalid Service")
 )
@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains the checks of the channels that clients of the
+// gateways may access. Private inboxes and system channels are reserved
+// for the services, regardless of the policy.
+
+var (
+	// SystemChannels are the channels that are used by the services
+	// to register their channels. Clients must not send events to them.
+	SystemChannels = []string{broker.ChannelSubscribe, broker.ChannelUnsubscribe}
+)
+
+// ReservedChannel checks if the channel is reserved for the services,
+// which is the case for private inboxes and system channels. Clients
+// could otherwise spoof replies or the registrations of services.
+func ReservedChannel(channel string) bool {
+	if inbox(channel) {
+		return true
+	}
+
+	for _, system := range SystemChannels {
+		if channel == system {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReservedPattern checks if the pattern of a subscription may match private
+// inboxes, which carry the replies of other clients. Therefore, the first
+// hierarchy level of a pattern must neither be a wildcard nor the inbox prefix.
+func ReservedPattern(pattern string) bool {
+	first := strings.Split(pattern, broker.ChannelSeparator)[0]
+	return inbox(pattern) || first == broker.ChannelWildcardSingle || first == broker.ChannelWildcardMulti
+}
+
+// inbox checks if the channel or pattern addresses a private inbox.
+func inbox(channel string) bool {
+	return strings.Split(channel, broker.ChannelSeparator)[0] == broker.InboxPrefix
+}
@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"testing"
+)
+
+func TestReservedChannel(t *testing.T) {
+	tests := []struct {
+		name     string
+		channel  string
+		reserved bool
+	}{
+		{name: "inbox", channel: "_INBOX.abc", reserved: true},
+		{name: "nested inbox", channel: "_INBOX.abc.def", reserved: true},
+		{name: "inbox prefix", channel: "_INBOX", reserved: true},
+		{name: "channel registration", channel: "channels.create", reserved: true},
+		{name: "channel unregistration", channel: "channels.delete", reserved: true},
+		{name: "channel list", channel: "channels.find"},
+		{name: "resource", channel: "v1.pets.create"},
+		{name: "nested inbox level", channel: "v1._INBOX.create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reserved := ReservedChannel(tt.channel); reserved != tt.reserved {
+				t.Errorf("expected %v, got %v", tt.reserved, reserved)
+			}
+		})
+	}
+}
+
+func TestReservedPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		reserved bool
+	}{
+		{name: "everything", pattern: ">", reserved: true},
+		{name: "first level", pattern: "*", reserved: true},
+		{name: "wildcard first level", pattern: "*.create", reserved: true},
+		{name: "all inboxes", pattern: "_INBOX.>", reserved: true},
+		{name: "inbox", pattern: "_INBOX.abc", reserved: true},
+		{name: "channels", pattern: "channels.>"},
+		{name: "resource", pattern: "v1.pets.*"},
+		{name: "all resources", pattern: "v1.>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reserved := ReservedPattern(tt.pattern); reserved != tt.reserved {
+				t.Errorf("expected %v, got %v", tt.reserved, reserved)
+			}
+		})
+	}
+}
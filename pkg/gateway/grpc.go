@@ -0,0 +1,477 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/gateway/pb"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains a gateway, which offers a generic gRPC service for
+// all channels as defined in `api/proto/gateway.proto`. Events are sent
+// in the protobuf format of cloud events, which allows clients to use
+// typed stubs and deadlines instead of the translation of HTTP paths:
+//
+//   Invoke({ channel: "v1.pets.create", event: { text_data: "{...}" } })
+//   Subscribe({ pattern: "channels.>" })
+//
+// Errors are translated into the gRPC status codes that correspond to the
+// status of the `errs.ServiceError`, which is also sent as trailer.
+
+const (
+	GRPCMethodInvoke    = "Invoke"
+	GRPCMethodSubscribe = "Subscribe"
+
+	// GRPCMetadataStatus is the trailer that contains the status of an error.
+	GRPCMetadataStatus = "status"
+
+	// DefaultGRPCPendingLimit is the number of events that may be
+	// queued for a subscriber before the stream is closed.
+	DefaultGRPCPendingLimit = 256
+)
+
+var (
+	ErrGRPCSlowConsumer = status.Error(codes.ResourceExhausted, "gateway: slow consumer")
+	ErrGRPCShutdown     = status.Error(codes.Unavailable, "gateway: shutting down")
+)
+
+type GRPC struct {
+	pb.UnimplementedGatewayServer
+
+	service *service.Service
+
+	options   *Options
+	server    *grpc.Server
+	handlers  []service.RequestHandler
+	closing   chan bool
+	listening int32
+}
+
+// GRPCContext is the context of a call of the gRPC service. It is
+// passed to the middlewares as the context of the service request.
+type GRPCContext struct {
+	// Method is either `Invoke` or `Subscribe`.
+	Method string
+	// Channel is the channel of `Invoke` or the pattern of `Subscribe`.
+	Channel string
+
+	gateway     *GRPC
+	metadata    metadata.MD
+	userContext context.Context
+	locals      map[string]interface{}
+	index       int
+	dispatch    func() error
+}
+
+// NewGRPC creates and configures a new gRPC gateway.
+// For more information about the protocol translation
+// refer to: https://docs.mykil.io/ecosystem/concepts.html
+func NewGRPC(options ...Option) service.Gateway {
+	opts := GetDefaultOptions()
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return nil
+			}
+		}
+	}
+	return &GRPC{
+		options: &opts,
+		closing: make(chan bool),
+	}
+}
+
+func (g *GRPC) Bind(svc *service.Service) {
+	g.service = svc
+
+	g.server = grpc.NewServer()
+	pb.RegisterGatewayServer(g.server, g)
+	// Reflection allows generic clients, such as grpcurl, to discover the service.
+	reflection.Register(g.server)
+}
+
+func (g *GRPC) Route(requestHandler service.RequestHandler) {
+	g.handlers = append(g.handlers, requestHandler)
+}
+
+func (g *GRPC) Listen() {
+	// Check if the port is valid.
+	if g.options.Port == "" {
+		g.service.Logger.Warn().Msg("Missing environment variable: PORT")
+		g.options.Port = DefaultGatewayPort
+		g.service.Logger.Warn().Msgf("Using default port: %s/tcp", g.options.Port)
+	}
+
+	listener, err := net.Listen("tcp", ":"+g.options.Port)
+	if err != nil {
+		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
+	}
+
+	atomic.StoreInt32(&g.listening, 1)
+
+	g.service.Logger.Info().Msgf("Gateway online: %s/tcp", g.options.Port)
+	if err := g.server.Serve(listener); err != nil {
+		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
+	}
+}
+
+func (g *GRPC) Listening() bool {
+	return atomic.LoadInt32(&g.listening) == 1
+}
+
+// Shutdown stops accepting calls, closes all subscriptions and waits
+// until the in-flight calls completed or the context is done.
+func (g *GRPC) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&g.listening, 0)
+	close(g.closing)
+
+	stopped := make(chan bool)
+	go func() {
+		g.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		g.server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (g *GRPC) Context(r *service.Request) *GRPCContext {
+	// Do not check for successful type assertion. This should
+	// fail dramatically if the type is wrong.
+	return r.Context.(*GRPCContext)
+}
+
+// Invoke sends the data of the event as request to the channel.
+func (g *GRPC) Invoke(ctx context.Context, req *pb.InvokeRequest) (*pb.CloudEvent, error) {
+	// The deadline of the client is kept if it is shorter than the timeout.
+	ctx, cancel := context.WithTimeout(ctx, g.options.Timeout)
+	defer cancel()
+
+	// Requests are sent to a concrete channel, which is authorized as such.
+	if req.Channel == "" || strings.ContainsAny(req.Channel, "*>") {
+		return nil, statusFromError(errs.InvalidEndpoint, func(md metadata.MD) {
+			grpc.SetTrailer(ctx, md)
+		})
+	}
+	if ReservedChannel(req.Channel) {
+		return nil, statusFromError(errs.InvalidCredentials, func(md metadata.MD) {
+			grpc.SetTrailer(ctx, md)
+		})
+	}
+
+	var response *pb.CloudEvent
+	c := g.newContext(ctx, GRPCMethodInvoke, req.Channel)
+	c.dispatch = func() error {
+		data, err := dataFromProto(req.Event)
+		if err != nil {
+			return err
+		}
+
+		res, err := g.service.Broker.RequestContext(c.userContext, req.Channel, data)
+		if err != nil {
+			return errs.InvalidService
+		}
+
+		// Pass errors of the service to the error translation.
+		if err := res.Err(); err != nil {
+			return err
+		}
+
+		response = eventToProto(res.Cloudevent)
+		return nil
+	}
+
+	if err := c.Next(); err != nil {
+		return nil, statusFromError(err, func(md metadata.MD) {
+			grpc.SetTrailer(ctx, md)
+		})
+	}
+
+	return response, nil
+}
+
+// Subscribe streams the events of the pattern until the client cancels
+// the call. If the client does not receive events fast enough, the
+// stream is closed.
+func (g *GRPC) Subscribe(req *pb.SubscribeRequest, stream pb.Gateway_SubscribeServer) error {
+	if req.Pattern == "" {
+		return statusFromError(errs.InvalidEndpoint, stream.SetTrailer)
+	}
+	if ReservedPattern(req.Pattern) {
+		return statusFromError(errs.InvalidCredentials, stream.SetTrailer)
+	}
+
+	c := g.newContext(stream.Context(), GRPCMethodSubscribe, req.Pattern)
+	c.dispatch = func() error {
+		events := make(chan *cloudevents.Event, DefaultGRPCPendingLimit)
+		overflow := make(chan bool)
+		subscription, err := g.service.Broker.Watch(req.Pattern, func(ec *service.Context) error {
+			select {
+			case events <- ec.Cloudevent:
+			default:
+				select {
+				case <-overflow:
+				default:
+					close(overflow)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errs.InvalidService
+		}
+		defer subscription.Unsubscribe()
+
+		// Send the headers to signal that the subscription is active.
+		if err := stream.SendHeader(metadata.MD{}); err != nil {
+			return err
+		}
+
+		for {
+			select {
+			case event := <-events:
+				if err := stream.Send(eventToProto(event)); err != nil {
+					return err
+				}
+			case <-overflow:
+				g.service.Logger.Warn().Msgf("Slow consumer, closing subscription: %s", req.Pattern)
+				return ErrGRPCSlowConsumer
+			case <-stream.Context().Done():
+				return nil
+			case <-g.closing:
+				return ErrGRPCShutdown
+			}
+		}
+	}
+
+	if err := c.Next(); err != nil {
+		return statusFromError(err, stream.SetTrailer)
+	}
+
+	return nil
+}
+
+// newContext creates the context that is passed to the middlewares.
+func (g *GRPC) newContext(ctx context.Context, method string, channel string) *GRPCContext {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	return &GRPCContext{
+		Method:      method,
+		Channel:     channel,
+		gateway:     g,
+		metadata:    md,
+		userContext: ctx,
+		locals:      make(map[string]interface{}),
+		index:       -1,
+	}
+}
+
+// Get returns the metadata of the call, which is case-insensitive.
+func (c *GRPCContext) Get(key string) string {
+	values := c.metadata.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Locals stores the value for the key if it is provided and returns the
+// value of the key, which allows to pass data between middlewares.
+func (c *GRPCContext) Locals(key string, value ...interface{}) interface{} {
+	if len(value) > 0 {
+		c.locals[key] = value[0]
+	}
+
+	return c.locals[key]
+}
+
+// UserContext returns the context of the call, which contains its deadline.
+func (c *GRPCContext) UserContext() context.Context {
+	return c.userContext
+}
+
+// SetUserContext replaces the context of the call.
+func (c *GRPCContext) SetUserContext(ctx context.Context) {
+	c.userContext = ctx
+}
+
+// Next invokes the next middleware. Once all middlewares passed
+// the call on, the call is dispatched to the broker.
+func (c *GRPCContext) Next() error {
+	c.index++
+	if c.index >= len(c.gateway.handlers) {
+		return c.dispatch()
+	}
+
+	return c.gateway.handlers[c.index](&service.Request{
+		Context: c,
+		Service: c.gateway.service,
+	})
+}
+
+// statusFromError translates errors into a gRPC status. Errors that
+// are not an `errs.ServiceError` are sent as unexpected errors.
+func statusFromError(err error, setTrailer func(metadata.MD)) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var svcErr *errs.ServiceError
+	if !errors.As(err, &svcErr) {
+		svcErr = errs.UnexpectedError
+	}
+
+	setTrailer(metadata.Pairs(GRPCMetadataStatus, strconv.Itoa(svcErr.Status)))
+	return status.Error(codeFromStatus(svcErr.Status), svcErr.Message)
+}
+
+// codeFromStatus returns the gRPC code that corresponds to the HTTP status.
+func codeFromStatus(s int) codes.Code {
+	switch s {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+
+	if s < http.StatusInternalServerError {
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// dataFromProto decodes the JSON data of the event.
+func dataFromProto(event *pb.CloudEvent) (interface{}, error) {
+	var raw []byte
+	switch data := event.GetData().(type) {
+	case nil:
+		return nil, nil
+	case *pb.CloudEvent_TextData:
+		raw = []byte(data.TextData)
+	case *pb.CloudEvent_BinaryData:
+		raw = data.BinaryData
+	default:
+		return nil, errs.InvalidData
+	}
+
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, errs.InvalidJSON
+	}
+
+	return body, nil
+}
+
+// eventToProto translates the cloud event into the protobuf format.
+func eventToProto(event *cloudevents.Event) *pb.CloudEvent {
+	message := &pb.CloudEvent{
+		Id:          event.ID(),
+		Source:      event.Source(),
+		SpecVersion: event.SpecVersion(),
+		Type:        event.Type(),
+		Attributes:  make(map[string]*pb.CloudEvent_CloudEventAttributeValue),
+	}
+
+	if contentType := event.DataContentType(); contentType != "" {
+		message.Attributes["datacontenttype"] = attributeToProto(contentType)
+	}
+	if schema := event.DataSchema(); schema != "" {
+		message.Attributes["dataschema"] = &pb.CloudEvent_CloudEventAttributeValue{
+			Attr: &pb.CloudEvent_CloudEventAttributeValue_CeUri{CeUri: schema},
+		}
+	}
+	if subject := event.Subject(); subject != "" {
+		message.Attributes["subject"] = attributeToProto(subject)
+	}
+	if !event.Time().IsZero() {
+		message.Attributes["time"] = attributeToProto(event.Time())
+	}
+	for name, value := range event.Extensions() {
+		message.Attributes[name] = attributeToProto(value)
+	}
+
+	// Data is sent as text if it is JSON or text, as it is human-readable.
+	if data := event.Data(); len(data) > 0 {
+		contentType := event.DataContentType()
+		if contentType == "" || strings.Contains(contentType, "json") || strings.HasPrefix(contentType, "text/") {
+			message.Data = &pb.CloudEvent_TextData{TextData: string(data)}
+		} else {
+			message.Data = &pb.CloudEvent_BinaryData{BinaryData: data}
+		}
+	}
+
+	return message
+}
+
+// attributeToProto translates the value of an attribute into the
+// protobuf format. Unknown types are sent as string.
+func attributeToProto(value interface{}) *pb.CloudEvent_CloudEventAttributeValue {
+	attribute := new(pb.CloudEvent_CloudEventAttributeValue)
+	switch v := value.(type) {
+	case bool:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeBoolean{CeBoolean: v}
+	case int32:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeInteger{CeInteger: v}
+	case string:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeString{CeString: v}
+	case []byte:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeBytes{CeBytes: v}
+	case types.URI:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeUri{CeUri: v.String()}
+	case types.URIRef:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeUriRef{CeUriRef: v.String()}
+	case types.Timestamp:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(v.Time)}
+	case time.Time:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeTimestamp{CeTimestamp: timestamppb.New(v)}
+	default:
+		attribute.Attr = &pb.CloudEvent_CloudEventAttributeValue_CeString{CeString: fmt.Sprint(v)}
+	}
+
+	return attribute
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nicklasfrahm/showcases/pkg/gateway/pb"
+)
+
+// subscribeStream is a stream of a subscription, which only accepts
+// its trailer. All other methods panic, as they must not be called.
+type subscribeStream struct {
+	pb.Gateway_SubscribeServer
+}
+
+func (s *subscribeStream) SetTrailer(metadata.MD) {}
+
+func TestGRPCReservedChannels(t *testing.T) {
+	g := NewGRPC().(*GRPC)
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{
+			name: "invoke inbox",
+			call: func() error {
+				_, err := g.Invoke(context.Background(), &pb.InvokeRequest{Channel: "_INBOX.abc"})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "invoke system channel",
+			call: func() error {
+				_, err := g.Invoke(context.Background(), &pb.InvokeRequest{Channel: "channels.create"})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "invoke pattern",
+			call: func() error {
+				_, err := g.Invoke(context.Background(), &pb.InvokeRequest{Channel: "v1.pets.*"})
+				return err
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "subscribe everything",
+			call: func() error {
+				return g.Subscribe(&pb.SubscribeRequest{Pattern: ">"}, &subscribeStream{})
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "subscribe inboxes",
+			call: func() error {
+				return g.Subscribe(&pb.SubscribeRequest{Pattern: "_INBOX.>"}, &subscribeStream{})
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "subscribe wildcard first level",
+			call: func() error {
+				return g.Subscribe(&pb.SubscribeRequest{Pattern: "*.pets.created"}, &subscribeStream{})
+			},
+			code: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != tt.code {
+				t.Fatalf("expected code %v, got %v", tt.code, code)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package gateway
 
 import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -18,8 +23,10 @@ type HTTPOptions struct {
 type HTTP struct {
 	service *service.Service
 
-	options *Options
-	app     *fiber.App
+	options   *Options
+	app       *fiber.App
+	listening int32
+	closing   chan bool
 }
 
 // NewHTTP creates and configures a new HTTP gateway.
@@ -36,6 +43,7 @@ func NewHTTP(options ...Option) service.Gateway {
 	}
 	return &HTTP{
 		options: &opts,
+		closing: make(chan bool),
 	}
 }
 
@@ -51,15 +59,21 @@ func (g *HTTP) Bind(svc *service.Service) {
 	g.app.Use(recover.New())
 	g.app.Use(helmet.New())
 	g.app.Use(cors.New(cors.Config{
-		AllowHeaders:     "Accept,Authorization,Content-Type,X-CSRF-Token",
+		AllowHeaders:     "Accept,Authorization,Content-Type,X-API-Key,X-CSRF-Token",
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		ExposeHeaders:    "Location,ETag,Last-Modified,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After",
 		AllowCredentials: true,
 		MaxAge:           600,
 	}))
 	g.app.Use(compress.New(compress.Config{
+		// Compression buffers the body, which prevents streaming events.
+		Next: func(c *fiber.Ctx) bool {
+			return strings.Contains(c.Get(fiber.HeaderAccept), MIMETextEventStream)
+		},
 		Level: compress.LevelBestCompression,
 	}))
 	g.app.Use(MiddlewareRedirectSlashes())
+	g.app.Use(MiddlewareDeadline(g.options.Timeout))
 	g.app.Use(MiddlewareContentType(fiber.MIMEApplicationJSONCharsetUTF8))
 }
 
@@ -83,12 +97,53 @@ func (g *HTTP) Listen() {
 		g.service.Logger.Warn().Msgf("Using default port: %s/tcp", g.options.Port)
 	}
 
+	listener, err := net.Listen("tcp", ":"+g.options.Port)
+	if err != nil {
+		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
+	}
+	atomic.StoreInt32(&g.listening, 1)
+
 	g.service.Logger.Info().Msgf("Gateway online: %s/tcp", g.options.Port)
-	if err := g.app.Listen(":" + g.options.Port); err != nil {
+	if err := g.app.Listener(listener); err != nil {
 		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
 	}
 }
 
+func (g *HTTP) Listening() bool {
+	return atomic.LoadInt32(&g.listening) == 1
+}
+
+// Shutdown stops accepting connections and waits until the
+// in-flight requests completed or the context is done. Long-lived
+// connections, such as event streams, are closed immediately.
+func (g *HTTP) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&g.listening, 0)
+	close(g.closing)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- g.app.Shutdown()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Closing returns a channel that is closed once the HTTP gateway of the
+// service shuts down, such that long-lived connections can be closed.
+// The channel is never closed if the service uses another gateway.
+func Closing(svc *service.Service) <-chan bool {
+	if g, ok := svc.Gateway.(*HTTP); ok {
+		return g.closing
+	}
+
+	return nil
+}
+
 func (g *HTTP) Context(r *service.Request) *fiber.Ctx {
 	// Do not check for successful type assertion. This should
 	// fail dramatically if the type is wrong.
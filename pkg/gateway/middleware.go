@@ -1,8 +1,11 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 
@@ -22,11 +25,24 @@ func MiddlewareContentType(contentType string) func(*fiber.Ctx) error {
 	}
 }
 
+// MiddlewareDeadline returns a middleware that sets a deadline for the request
+// on the user context, which can be passed to the broker.
+func MiddlewareDeadline(timeout time.Duration) func(*fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
 // MiddlewareError returns a middleware to handler errors during requests.
 func MiddlewareError() func(*fiber.Ctx, error) error {
 	return func(c *fiber.Ctx, err error) error {
 		// Handle known service error types.
-		if svcErr, ok := err.(*errs.ServiceError); ok {
+		var svcErr *errs.ServiceError
+		if errors.As(err, &svcErr) {
 			return c.Status(svcErr.Status).JSON(ErrorResponse{
 				Error: *svcErr,
 			})
@@ -0,0 +1,420 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains a gateway, which bridges an MQTT 5 server. Clients
+// publish requests on topics below the topic prefix, such as
+// `requests/pets/create`, which are passed to the middlewares of the
+// gateway. Responses and errors are published on the response topic of
+// the request and carry its correlation data, as well as the status of
+// the response as user property, for example:
+//
+//   > PUBLISH requests/pets/create { "name": "Tom" }
+//       response topic: clients/alice/responses
+//       correlation data: 1
+//       user property: authorization=Basic ...
+//   < PUBLISH clients/alice/responses { "id": "..." }
+//       correlation data: 1
+//       user property: status=201
+//
+// Requests with a response topic below the topic prefix are ignored,
+// as the gateway would otherwise receive its own responses.
+
+const (
+	// DefaultMQTTGatewayConcurrency is the number of requests that are
+	// processed concurrently before no more messages are read.
+	DefaultMQTTGatewayConcurrency = 64
+	DefaultMQTTGatewayKeepAlive   = 30
+
+	// MQTTPropertyStatus is the user property that contains the status of a response.
+	MQTTPropertyStatus = "status"
+)
+
+var (
+	ErrMQTTGatewayDisconnected = errors.New("gateway: disconnected from mqtt server")
+)
+
+type MQTT struct {
+	service *service.Service
+
+	options     *Options
+	uri         *url.URL
+	redactedURI string
+	client      *paho.Client
+	handlers    []service.RequestHandler
+	requests    chan bool
+	done        chan error
+	listening   int32
+}
+
+// MQTTContext is the context of a request received via MQTT. It is
+// passed to the middlewares as the context of the service request.
+type MQTTContext struct {
+	Message *paho.Publish
+
+	gateway     *MQTT
+	userContext context.Context
+	locals      map[string]interface{}
+	index       int
+}
+
+// NewMQTT creates and configures a new MQTT gateway.
+// For more information about the protocol translation
+// refer to: https://docs.mykil.io/ecosystem/concepts.html
+func NewMQTT(options ...Option) service.Gateway {
+	opts := GetDefaultOptions()
+	for _, opt := range options {
+		if opt != nil {
+			if err := opt(&opts); err != nil {
+				return nil
+			}
+		}
+	}
+	return &MQTT{
+		options:  &opts,
+		requests: make(chan bool, DefaultMQTTGatewayConcurrency),
+		done:     make(chan error, 1),
+	}
+}
+
+func (g *MQTT) Bind(svc *service.Service) {
+	g.service = svc
+}
+
+func (g *MQTT) Route(requestHandler service.RequestHandler) {
+	g.handlers = append(g.handlers, requestHandler)
+}
+
+func (g *MQTT) Listen() {
+	// Ensure that URI is provided.
+	if g.options.URI == "" {
+		g.service.Logger.Fatal().Msg("Configuration missing: GATEWAY_URI")
+	}
+
+	// Parse URI to redact secrets.
+	uri, err := url.Parse(g.options.URI)
+	if err != nil {
+		g.service.Logger.Fatal().Msg("Configuration invalid: GATEWAY_URI")
+	}
+	g.uri = uri
+	redacted := *uri
+	redacted.User = nil
+	g.redactedURI = redacted.String()
+
+	if err := g.connect(); err != nil {
+		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
+	}
+
+	atomic.StoreInt32(&g.listening, 1)
+
+	g.service.Logger.Info().Msgf("Gateway online: %s", g.redactedURI)
+	if err := <-g.done; err != nil {
+		g.service.Logger.Fatal().Err(err).Msg("Failed running gateway")
+	}
+}
+
+func (g *MQTT) Listening() bool {
+	return atomic.LoadInt32(&g.listening) == 1
+}
+
+// connect connects to the server and subscribes to the topic filters.
+func (g *MQTT) connect() error {
+	var conn net.Conn
+	var err error
+	switch g.uri.Scheme {
+	case "mqtt", "tcp":
+		conn, err = net.Dial("tcp", g.uri.Host)
+	case "mqtts", "ssl", "tls":
+		conn, err = tls.Dial("tcp", g.uri.Host, nil)
+	default:
+		return broker.ErrMQTTUnsupportedScheme
+	}
+	if err != nil {
+		return err
+	}
+
+	// Client identifiers must be unique, because the server
+	// will otherwise disconnect the previous client.
+	clientID := g.service.Config.Name + "-" + strings.Split(uuid.NewString(), "-")[0]
+	g.client = paho.NewClient(paho.ClientConfig{
+		ClientID: clientID,
+		Conn:     conn,
+		Router:   paho.NewSingleHandlerRouter(g.receive),
+		OnClientError: func(err error) {
+			g.disconnected(err)
+		},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			g.disconnected(ErrMQTTGatewayDisconnected)
+		},
+	})
+
+	connect := &paho.Connect{
+		ClientID:   clientID,
+		KeepAlive:  DefaultMQTTGatewayKeepAlive,
+		CleanStart: true,
+	}
+	if g.uri.User != nil {
+		connect.Username = g.uri.User.Username()
+		connect.UsernameFlag = true
+		if password, ok := g.uri.User.Password(); ok {
+			connect.Password = []byte(password)
+			connect.PasswordFlag = true
+		}
+	}
+
+	connack, err := g.client.Connect(context.Background(), connect)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if connack.ReasonCode != 0 {
+		conn.Close()
+		return broker.ErrMQTTConnectionRefused
+	}
+
+	subscriptions := make(map[string]paho.SubscribeOptions)
+	for _, filter := range g.filters() {
+		// Retained messages are not handled as requests.
+		subscriptions[filter] = paho.SubscribeOptions{QoS: 1, RetainHandling: 2}
+	}
+
+	suback, err := g.client.Subscribe(context.Background(), &paho.Subscribe{
+		Subscriptions: subscriptions,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Reason codes of 0x80 or greater indicate a failure.
+	for _, reason := range suback.Reasons {
+		if reason >= 0x80 {
+			return broker.ErrMQTTSubscriptionFailed
+		}
+	}
+
+	return nil
+}
+
+// filters returns the topic filters of the gateway below the topic prefix.
+// Shared subscriptions distribute the requests among all instances.
+func (g *MQTT) filters() []string {
+	filters := make([]string, len(g.options.Topics))
+	for i, topic := range g.options.Topics {
+		filters[i] = strings.Join([]string{broker.MQTTSharedPrefix, g.service.Config.Name, g.options.TopicPrefix, topic}, broker.MQTTTopicSeparator)
+	}
+
+	return filters
+}
+
+// requestTopic checks if the topic is below the topic prefix.
+func (g *MQTT) requestTopic(topic string) bool {
+	return strings.HasPrefix(topic, g.options.TopicPrefix+broker.MQTTTopicSeparator)
+}
+
+// Shutdown unsubscribes from the topic filters and waits until the
+// in-flight requests completed or the context is done.
+func (g *MQTT) Shutdown(ctx context.Context) error {
+	if g.client == nil {
+		return nil
+	}
+	atomic.StoreInt32(&g.listening, 0)
+
+	if _, err := g.client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: g.filters()}); err != nil {
+		return err
+	}
+
+	// Requests are completed once all of their slots are acquired.
+	for i := 0; i < cap(g.requests); i++ {
+		select {
+		case g.requests <- true:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	g.disconnected(nil)
+	return g.client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+}
+
+// disconnected stops the gateway once the connection is lost.
+func (g *MQTT) disconnected(err error) {
+	atomic.StoreInt32(&g.listening, 0)
+
+	select {
+	case g.done <- err:
+	default:
+	}
+}
+
+// receive handles the message in the background, such that further
+// messages are read until the limit of concurrent requests is reached.
+func (g *MQTT) receive(msg *paho.Publish) {
+	// Responses must not be published below the topic prefix, where
+	// they would be received again as requests.
+	if msg.Properties != nil && g.requestTopic(msg.Properties.ResponseTopic) {
+		g.service.Logger.Warn().Msgf("Response topic below topic prefix: %s", msg.Properties.ResponseTopic)
+		return
+	}
+
+	g.requests <- true
+	go func() {
+		defer func() { <-g.requests }()
+
+		// Set the deadline of the request, which is passed to the broker.
+		ctx, cancel := context.WithTimeout(context.Background(), g.options.Timeout)
+		defer cancel()
+
+		c := &MQTTContext{
+			Message:     msg,
+			gateway:     g,
+			userContext: ctx,
+			locals:      make(map[string]interface{}),
+			index:       -1,
+		}
+
+		if err := c.Next(); err != nil {
+			c.RespondError(err)
+		}
+	}()
+}
+
+func (g *MQTT) Context(r *service.Request) *MQTTContext {
+	// Do not check for successful type assertion. This should
+	// fail dramatically if the type is wrong.
+	return r.Context.(*MQTTContext)
+}
+
+// Topic returns the topic of the request.
+func (c *MQTTContext) Topic() string {
+	return c.Message.Topic
+}
+
+// Channel returns the channel of the request, which is
+// derived from the topic of the request below the prefix.
+func (c *MQTTContext) Channel() string {
+	topic := strings.TrimPrefix(c.Topic(), c.gateway.options.TopicPrefix+broker.MQTTTopicSeparator)
+	return broker.ChannelFromMQTTTopic(topic)
+}
+
+// Payload returns the payload of the request.
+func (c *MQTTContext) Payload() []byte {
+	return c.Message.Payload
+}
+
+// Get returns the user property of the request. Like HTTP
+// headers, the key of the property is case-insensitive.
+func (c *MQTTContext) Get(key string) string {
+	if c.Message.Properties == nil {
+		return ""
+	}
+
+	for _, property := range c.Message.Properties.User {
+		if strings.EqualFold(property.Key, key) {
+			return property.Value
+		}
+	}
+
+	return ""
+}
+
+// ResponseTopic returns the response topic of the request. It is
+// empty if the client does not expect a response.
+func (c *MQTTContext) ResponseTopic() string {
+	if c.Message.Properties == nil {
+		return ""
+	}
+
+	return c.Message.Properties.ResponseTopic
+}
+
+// Locals stores the value for the key if it is provided and returns the
+// value of the key, which allows to pass data between middlewares.
+func (c *MQTTContext) Locals(key string, value ...interface{}) interface{} {
+	if len(value) > 0 {
+		c.locals[key] = value[0]
+	}
+
+	return c.locals[key]
+}
+
+// UserContext returns the context of the request, which contains its deadline.
+func (c *MQTTContext) UserContext() context.Context {
+	return c.userContext
+}
+
+// SetUserContext replaces the context of the request.
+func (c *MQTTContext) SetUserContext(ctx context.Context) {
+	c.userContext = ctx
+}
+
+// Next invokes the next middleware. Requests that are not
+// handled by any middleware are rejected.
+func (c *MQTTContext) Next() error {
+	c.index++
+	if c.index >= len(c.gateway.handlers) {
+		return errs.InvalidEndpoint
+	}
+
+	return c.gateway.handlers[c.index](&service.Request{
+		Context: c,
+		Service: c.gateway.service,
+	})
+}
+
+// Respond publishes the payload on the response topic of the request.
+// Nothing is published if the client does not expect a response.
+func (c *MQTTContext) Respond(status int, payload []byte) error {
+	responseTopic := c.ResponseTopic()
+	if responseTopic == "" {
+		return nil
+	}
+
+	properties := &paho.PublishProperties{
+		CorrelationData: c.Message.Properties.CorrelationData,
+		ContentType:     fiber.MIMEApplicationJSONCharsetUTF8,
+	}
+	properties.User.Add(MQTTPropertyStatus, strconv.Itoa(status))
+
+	_, err := c.gateway.client.Publish(context.Background(), &paho.Publish{
+		Topic:      responseTopic,
+		QoS:        c.Message.QoS,
+		Payload:    payload,
+		Properties: properties,
+	})
+	return err
+}
+
+// RespondError responds with the error like the HTTP gateway. Errors
+// that are not an `errs.ServiceError` are sent as unexpected errors.
+func (c *MQTTContext) RespondError(err error) {
+	var svcErr *errs.ServiceError
+	if !errors.As(err, &svcErr) {
+		svcErr = errs.UnexpectedError
+	}
+
+	payload, err := json.Marshal(ErrorResponse{Error: *svcErr})
+	if err == nil {
+		err = c.Respond(svcErr.Status, payload)
+	}
+	if err != nil {
+		c.gateway.service.Logger.Warn().Err(err).Msgf("Failed to respond: %s", c.Topic())
+	}
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// startMQTT starts an embedded MQTT 5 server and returns its address.
+func startMQTT(t *testing.T) string {
+	t.Helper()
+
+	// Reserve a free port for the listener of the server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	server := mqtt.New(&mqtt.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	return address
+}
+
+func TestMQTTTopicPrefix(t *testing.T) {
+	address := startMQTT(t)
+
+	// The gateway responds with the channel of the request.
+	requests := make(chan string, 8)
+	g := NewMQTT(URI("mqtt://" + address)).(*MQTT)
+	g.Bind(service.New(service.Config{Name: "gateway"}))
+	g.Route(func(r *service.Request) error {
+		ctx := g.Context(r)
+		requests <- ctx.Channel()
+		return ctx.Respond(200, []byte(`"`+ctx.Channel()+`"`))
+	})
+	go g.Listen()
+	defer g.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := make(chan *paho.Publish, 8)
+	client := paho.NewClient(paho.ClientConfig{
+		Conn:   conn,
+		Router: paho.NewSingleHandlerRouter(func(msg *paho.Publish) { responses <- msg }),
+	})
+	if _, err := client.Connect(context.Background(), &paho.Connect{ClientID: "alice", KeepAlive: 30, CleanStart: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(&paho.Disconnect{})
+	if _, err := client.Subscribe(context.Background(), &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{"clients/alice/#": {QoS: 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the gateway subscribed to the topic filters.
+	deadline := time.Now().Add(time.Second)
+	for !g.Listening() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name          string
+		topic         string
+		responseTopic string
+		channel       string
+	}{
+		{name: "topic below prefix", topic: "requests/v1/pets/create", responseTopic: "clients/alice/responses", channel: "v1.pets.create"},
+		{name: "topic outside of prefix", topic: "v1/pets/create", responseTopic: "clients/alice/responses"},
+		{name: "response topic below prefix", topic: "requests/v1/pets/read", responseTopic: "requests/v1/pets/read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.Publish(context.Background(), &paho.Publish{
+				Topic: tt.topic,
+				QoS:   1,
+				Properties: &paho.PublishProperties{
+					ResponseTopic:   tt.responseTopic,
+					CorrelationData: []byte(tt.name),
+				},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.channel == "" {
+				select {
+				case channel := <-requests:
+					t.Fatalf("expected no request, got %s", channel)
+				case <-time.After(100 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case channel := <-requests:
+				if channel != tt.channel {
+					t.Errorf("expected channel %s, got %s", tt.channel, channel)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected request")
+			}
+
+			select {
+			case msg := <-responses:
+				if string(msg.Properties.CorrelationData) != tt.name {
+					t.Errorf("expected correlation data %s, got %s", tt.name, msg.Properties.CorrelationData)
+				}
+				if status := msg.Properties.User.Get(MQTTPropertyStatus); status != "200" {
+					t.Errorf("expected status 200, got %s", status)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected response")
+			}
+		})
+	}
+}
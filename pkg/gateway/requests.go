@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"github.com/rs/zerolog"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains the handling of requests that is shared by the
+// gateways, such that all protocols authorize clients and translate
+// responses the same way.
+
+// Authorize checks if the identity may access the channel. API keys
+// are authorized by their own channels instead of the policy, while
+// reserved channels are denied regardless of the policy.
+func Authorize(logger *zerolog.Logger, policy *auth.Policy, identity *auth.Identity, channel string) error {
+	allowed := !ReservedChannel(channel) && identity.Allowed(policy, channel)
+	return access(logger, identity, channel, allowed)
+}
+
+// AuthorizePattern checks if the identity may access all channels that
+// match the pattern of a subscription. Patterns that may match private
+// inboxes are denied regardless of the policy.
+func AuthorizePattern(logger *zerolog.Logger, policy *auth.Policy, identity *auth.Identity, pattern string) error {
+	allowed := !ReservedPattern(pattern) && identity.AllowedPattern(policy, pattern)
+	return access(logger, identity, pattern, allowed)
+}
+
+// access logs the decision of the authorization.
+func access(logger *zerolog.Logger, identity *auth.Identity, channel string, allowed bool) error {
+	if !allowed {
+		logger.Warn().Strs("roles", identity.Roles).Msgf("Access denied: %s", channel)
+		return errs.InvalidCredentials
+	}
+
+	logger.Info().Strs("roles", identity.Roles).Msgf("Access granted: %s", channel)
+	return nil
+}
+
+// ConsumeQuota counts a request against the quota of the API key of the
+// identity. Identities without an API key are not limited by a quota.
+func ConsumeQuota(apiKeys *auth.APIKeys, identity *auth.Identity) (auth.APIKeyUsage, error) {
+	if identity.APIKey == nil {
+		return auth.APIKeyUsage{}, nil
+	}
+
+	usage, err := apiKeys.Consume(identity.APIKey)
+	if err != nil {
+		return usage, errs.QuotaExceeded
+	}
+
+	return usage, nil
+}
+
+// ResponseStatus returns the status of the response to a request on the
+// channel. Services may set it explicitly, otherwise it is derived from
+// the action of the channel, such as `201 Created` for `.create`.
+func ResponseStatus(channel string, res *cloudevents.Event) int {
+	if status, err := types.ToInteger(res.Extensions()[service.ExtensionHTTPStatus]); err == nil {
+		return int(status)
+	}
+
+	if strings.HasSuffix(channel, ".create") {
+		return http.StatusCreated
+	}
+
+	if strings.HasSuffix(channel, ".delete") && isEmpty(res.Data()) {
+		return http.StatusNoContent
+	}
+
+	return http.StatusOK
+}
+
+// isEmpty checks if the JSON data of a response is empty.
+func isEmpty(data []byte) bool {
+	trimmed := strings.TrimSpace(string(data))
+	return trimmed == "" || trimmed == "null"
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/rs/zerolog"
+
+	"github.com/nicklasfrahm/showcases/pkg/auth"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+func TestResponseStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		status  interface{}
+		data    interface{}
+		want    int
+	}{
+		{name: "read", channel: "v1.pets.read", data: map[string]string{"name": "rex"}, want: http.StatusOK},
+		{name: "create", channel: "v1.pets.create", data: map[string]string{"id": "1"}, want: http.StatusCreated},
+		{name: "delete without data", channel: "v1.pets.delete", want: http.StatusNoContent},
+		{name: "delete with data", channel: "v1.pets.delete", data: map[string]string{"id": "1"}, want: http.StatusOK},
+		{name: "status of service", channel: "v1.pets.create", status: http.StatusAccepted, want: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := cloudevents.NewEvent()
+			if tt.data != nil {
+				if err := event.SetData(cloudevents.ApplicationJSON, tt.data); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.status != nil {
+				event.SetExtension(service.ExtensionHTTPStatus, tt.status)
+			}
+
+			if status := ResponseStatus(tt.channel, &event); status != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, status)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	logger := zerolog.Nop()
+	identity := &auth.Identity{Subject: "alice"}
+
+	tests := []struct {
+		name      string
+		authorize func(*zerolog.Logger, *auth.Policy, *auth.Identity, string) error
+		channel   string
+		err       error
+	}{
+		{name: "channel", authorize: Authorize, channel: "v1.pets.read"},
+		{name: "inbox", authorize: Authorize, channel: "_INBOX.abc", err: errs.InvalidCredentials},
+		{name: "system channel", authorize: Authorize, channel: "channels.create", err: errs.InvalidCredentials},
+		{name: "pattern", authorize: AuthorizePattern, channel: "v1.pets.>"},
+		{name: "pattern of inboxes", authorize: AuthorizePattern, channel: "_INBOX.>", err: errs.InvalidCredentials},
+		{name: "pattern of everything", authorize: AuthorizePattern, channel: ">", err: errs.InvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a policy, all other channels may be accessed.
+			if err := tt.authorize(&logger, nil, identity, tt.channel); err != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+// This file contains an endpoint for Server-Sent Events, which streams
+// the cloud events of a channel pattern to clients, for example:
+//
+//   GET /events?channel=channels.created
+//
+// If an event store is configured, the events are recorded, such that
+// clients may resume the stream via the `Last-Event-ID` header after
+// reconnecting. Heartbeats are sent as comments to keep idle connections
+// open. Clients that do not read fast enough are disconnected and may
+// resume afterwards.
+
+const (
+	DefaultSSEPath              = "/events"
+	DefaultSSEHeartbeatInterval = 15 * time.Second
+	DefaultSSEPendingLimit      = 256
+
+	MIMETextEventStream = "text/event-stream"
+	HeaderLastEventID   = "Last-Event-ID"
+	QueryChannel        = "channel"
+)
+
+var (
+	ErrEventNotFound = errors.New("gateway: event not found")
+)
+
+// EventStore records events, such that clients can resume their stream.
+type EventStore interface {
+	// Append records the event of the channel. Events that
+	// were already recorded are ignored.
+	Append(channel string, event *cloudevents.Event) error
+	// Since returns the events of channels matching the pattern
+	// that were recorded after the event with the ID.
+	Since(pattern string, id string) ([]*cloudevents.Event, error)
+}
+
+type SSEOptions struct {
+	// Path is the path of the endpoint.
+	Path string
+	// Channels are the patterns that clients may subscribe to.
+	Channels []string
+	// Authorize is called to authorize a client to subscribe to a pattern,
+	// which may contain wildcards.
+	Authorize func(r *service.Request, pattern string) error
+	// Store enables the resumption of streams if it is configured.
+	Store EventStore
+	// HeartbeatInterval is the interval of heartbeats.
+	HeartbeatInterval time.Duration
+	// PendingLimit is the number of events that may be queued
+	// for a client before the connection is closed.
+	PendingLimit int
+}
+
+// ServerSentEvents returns a request handler that streams the events of
+// the requested channel pattern. It must be used after the middlewares
+// that authenticate the request.
+func ServerSentEvents(opts *SSEOptions) service.RequestHandler {
+	if opts.Path == "" {
+		opts.Path = DefaultSSEPath
+	}
+
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = DefaultSSEHeartbeatInterval
+	}
+
+	if opts.PendingLimit == 0 {
+		opts.PendingLimit = DefaultSSEPendingLimit
+	}
+
+	recorder := &sseRecorder{options: opts, mutex: &sync.Mutex{}}
+
+	return func(r *service.Request) error {
+		ctx := r.Context.(*fiber.Ctx)
+		if ctx.Path() != opts.Path {
+			return ctx.Next()
+		}
+
+		pattern := ctx.Query(QueryChannel)
+		if pattern == "" || !allowedPattern(opts.Channels, pattern) {
+			return errs.InvalidEndpoint
+		}
+
+		// Broad channels, such as `>`, must not expose private inboxes.
+		if ReservedPattern(pattern) {
+			return errs.InvalidCredentials
+		}
+
+		if opts.Authorize != nil {
+			if err := opts.Authorize(r, pattern); err != nil {
+				return err
+			}
+		}
+
+		if err := recorder.start(r.Service); err != nil {
+			r.Logger().Warn().Err(err).Msg("Failed to record events")
+		}
+
+		// Subscribe before replaying, such that no event is missed.
+		events := make(chan *cloudevents.Event, opts.PendingLimit)
+		overflow := make(chan bool)
+		subscription, err := r.Service.Broker.Watch(pattern, func(ec *service.Context) error {
+			select {
+			case events <- ec.Cloudevent:
+			default:
+				select {
+				case <-overflow:
+				default:
+					close(overflow)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return errs.InvalidService
+		}
+
+		var replay []*cloudevents.Event
+		if lastEventID := ctx.Get(HeaderLastEventID); lastEventID != "" && opts.Store != nil {
+			replay, err = opts.Store.Since(pattern, lastEventID)
+			if err != nil {
+				r.Logger().Warn().Err(err).Msgf("Failed to resume events: %s", lastEventID)
+			}
+		}
+
+		ctx.Set(fiber.HeaderContentType, MIMETextEventStream)
+		ctx.Set(fiber.HeaderCacheControl, "no-cache")
+		ctx.Set(fiber.HeaderConnection, "keep-alive")
+		ctx.Set("X-Accel-Buffering", "no")
+
+		logger := r.Logger()
+		closing := Closing(r.Service)
+		ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer subscription.Unsubscribe()
+
+			heartbeat := time.NewTicker(opts.HeartbeatInterval)
+			defer heartbeat.Stop()
+
+			// Skip live events that were already replayed.
+			replayed := make(map[string]bool)
+			for _, event := range replay {
+				replayed[event.ID()] = true
+				if err := writeEvent(w, event); err != nil {
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			for {
+				select {
+				case event := <-events:
+					if replayed[event.ID()] {
+						continue
+					}
+					if err := writeEvent(w, event); err != nil {
+						return
+					}
+				case <-heartbeat.C:
+					if _, err := w.WriteString(": heartbeat\n\n"); err != nil {
+						return
+					}
+				case <-overflow:
+					logger.Warn().Msgf("Slow consumer, closing event stream: %s", pattern)
+					return
+				case <-closing:
+					// Clients resume the stream via another gateway.
+					return
+				}
+
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+
+		return nil
+	}
+}
+
+// writeEvent writes the cloud event as server-sent event.
+func writeEvent(w *bufio.Writer, event *cloudevents.Event) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID(), event.Type(), encoded)
+	return err
+}
+
+// allowedPattern checks if the pattern only matches channels that are
+// matched by any of the allowed patterns.
+func allowedPattern(allowed []string, pattern string) bool {
+	for _, allowedPattern := range allowed {
+		if broker.CoversPattern(allowedPattern, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sseRecorder records the events of all allowed channels in the store.
+type sseRecorder struct {
+	options  *SSEOptions
+	watching []service.Subscription
+	mutex    *sync.Mutex
+}
+
+// start watches the allowed channels once the broker is connected. It is
+// retried for every client until it succeeded for all channels.
+func (recorder *sseRecorder) start(svc *service.Service) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.options.Store == nil {
+		return nil
+	}
+
+	for _, pattern := range recorder.options.Channels[len(recorder.watching):] {
+		subscription, err := svc.Broker.Watch(pattern, func(ctx *service.Context) error {
+			// Events are stored by their channel, because the type of
+			// an event does not need to match the channel it was sent to.
+			// Replies are not recorded, as they can not be streamed.
+			if inbox(ctx.Channel) {
+				return nil
+			}
+			return recorder.options.Store.Append(ctx.Channel, ctx.Cloudevent)
+		})
+		if err != nil {
+			return err
+		}
+		recorder.watching = append(recorder.watching, subscription)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains an event store that keeps the latest events in
+// memory. It allows clients to resume their stream as long as they
+// reconnect to the same instance of the gateway.
+
+const (
+	DefaultEventStoreLimit = 1000
+)
+
+type recordedEvent struct {
+	channel string
+	event   *cloudevents.Event
+}
+
+// MemoryEventStore keeps a limited number of events in memory.
+type MemoryEventStore struct {
+	limit  int
+	events []*recordedEvent
+	// offset is the sequence number of the first event.
+	offset    int
+	sequences map[string]int
+	mutex     *sync.RWMutex
+}
+
+// NewMemoryEventStore creates a new store that keeps up to limit events.
+func NewMemoryEventStore(limit int) *MemoryEventStore {
+	if limit == 0 {
+		limit = DefaultEventStoreLimit
+	}
+
+	return &MemoryEventStore{
+		limit:     limit,
+		sequences: make(map[string]int),
+		mutex:     &sync.RWMutex{},
+	}
+}
+
+func (store *MemoryEventStore) Append(channel string, event *cloudevents.Event) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.sequences[event.ID()]; ok {
+		return nil
+	}
+
+	store.sequences[event.ID()] = store.offset + len(store.events)
+	store.events = append(store.events, &recordedEvent{channel: channel, event: event})
+
+	// Evict the oldest event once the limit is exceeded.
+	if len(store.events) > store.limit {
+		delete(store.sequences, store.events[0].event.ID())
+		store.events[0] = nil
+		store.events = store.events[1:]
+		store.offset += 1
+	}
+
+	return nil
+}
+
+func (store *MemoryEventStore) Since(pattern string, id string) ([]*cloudevents.Event, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	sequence, ok := store.sequences[id]
+	if !ok {
+		return nil, ErrEventNotFound
+	}
+
+	events := make([]*cloudevents.Event, 0)
+	for _, recorded := range store.events[sequence-store.offset+1:] {
+		if broker.MatchChannel(pattern, recorded.channel) {
+			events = append(events, recorded.event)
+		}
+	}
+
+	return events, nil
+}
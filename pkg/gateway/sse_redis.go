@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains an event store based on Redis Streams, which is
+// shared by all instances of the gateway. The stream entry of every
+// event is indexed by the event ID, which prevents instances from
+// recording the same event twice.
+
+const (
+	DefaultRedisEventStoreKey = "events"
+	DefaultRedisEventStoreTTL = 1 * time.Hour
+)
+
+var appendScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 1 then
+  return 0
+end
+
+local entry = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "channel", ARGV[2], "event", ARGV[3])
+redis.call("SET", KEYS[2], entry, "PX", ARGV[4])
+
+return 1
+`)
+
+type RedisEventStoreOptions struct {
+	Client *redis.Client
+	// Key is the key of the stream.
+	Key string
+	// Limit is the approximate number of events that are kept.
+	Limit int
+	// TTL is the duration for which clients can resume via an event.
+	TTL time.Duration
+}
+
+// RedisEventStore keeps a limited number of events in a Redis stream.
+type RedisEventStore struct {
+	options *RedisEventStoreOptions
+}
+
+// NewRedisEventStore creates a new store that uses the Redis client.
+func NewRedisEventStore(opts *RedisEventStoreOptions) *RedisEventStore {
+	if opts.Key == "" {
+		opts.Key = DefaultRedisEventStoreKey
+	}
+
+	if opts.Limit == 0 {
+		opts.Limit = DefaultEventStoreLimit
+	}
+
+	if opts.TTL == 0 {
+		opts.TTL = DefaultRedisEventStoreTTL
+	}
+
+	return &RedisEventStore{
+		options: opts,
+	}
+}
+
+func (store *RedisEventStore) Append(channel string, event *cloudevents.Event) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{store.options.Key, store.indexKey(event.ID())}
+	return appendScript.Run(context.Background(), store.options.Client, keys,
+		store.options.Limit, channel, encoded, store.options.TTL.Milliseconds()).Err()
+}
+
+func (store *RedisEventStore) Since(pattern string, id string) ([]*cloudevents.Event, error) {
+	ctx := context.Background()
+
+	entry, err := store.options.Client.Get(ctx, store.indexKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := store.options.Client.XRange(ctx, store.options.Key, entry, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*cloudevents.Event, 0)
+	for _, message := range messages {
+		// The range includes the entry of the event itself.
+		if message.ID == entry {
+			continue
+		}
+
+		channel, _ := message.Values["channel"].(string)
+		if !broker.MatchChannel(pattern, channel) {
+			continue
+		}
+
+		encoded, _ := message.Values["event"].(string)
+		event := cloudevents.NewEvent()
+		if err := json.Unmarshal([]byte(encoded), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// indexKey returns the key that references the stream entry of the event.
+func (store *RedisEventStore) indexKey(id string) string {
+	return store.options.Key + ":" + id
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+func TestSSERecorder(t *testing.T) {
+	svc := service.New(service.Config{Name: "gateway"})
+	svc.UseBroker(broker.NewMemory(&broker.MemoryOptions{Bus: broker.NewMemoryBus()}))
+	if err := svc.Broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Broker.Disconnect()
+
+	store := NewMemoryEventStore(0)
+	recorder := &sseRecorder{
+		options: &SSEOptions{Channels: []string{">"}, Store: store},
+		mutex:   &sync.Mutex{},
+	}
+	if err := recorder.start(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Broker.Subscribe("v1.pets.read", func(ctx *service.Context) error {
+		return ctx.Reply("Tom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The type of an event does not need to match its channel.
+	event := cloudevents.NewEvent()
+	event.SetID("created")
+	event.SetSource("test")
+	event.SetType("pets.created")
+	if err := svc.Broker.PublishEvent("v1.pets.created", &event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Broker.Request("v1.pets.read", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Broker.Publish("v1.pets.deleted", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait until the last event was recorded. Registrations of
+	// channels are recorded as well, but their order is unknown.
+	var channels []string
+	deadline := time.Now().Add(time.Second)
+	for len(channels) == 0 || channels[len(channels)-1] != "v1.pets.deleted" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected last event on channel v1.pets.deleted, got %v", channels)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		channels = nil
+		store.mutex.RLock()
+		for _, recorded := range store.events {
+			if !strings.HasPrefix(recorded.channel, "channels.") {
+				channels = append(channels, recorded.channel)
+			}
+		}
+		store.mutex.RUnlock()
+	}
+
+	// Replies to private inboxes are not recorded.
+	expected := []string{"v1.pets.created", "v1.pets.read", "v1.pets.deleted"}
+	if strings.Join(channels, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected events on channels %v, got %v", expected, channels)
+	}
+
+	replayed, err := store.Since("v1.pets.*", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 2 {
+		t.Errorf("expected 2 replayed events, got %d", len(replayed))
+	}
+}
@@ -1,19 +1,42 @@
 package gateway
 
+import (
+	"errors"
+	"time"
+)
+
 const (
-	DefaultGatewayPort = "8080"
-	DefaultPrefork     = false
+	DefaultGatewayPort    = "8080"
+	DefaultPrefork        = false
+	DefaultGatewayTimeout = 10 * time.Second
+	DefaultGatewayTopic   = "#"
+	// DefaultGatewayTopicPrefix is the topic level below which
+	// the MQTT gateway receives the requests of clients.
+	DefaultGatewayTopicPrefix = "requests"
+)
+
+var (
+	ErrEmptyTopicPrefix = errors.New("gateway: topic prefix must not be empty")
 )
 
 type Options struct {
 	Port    string
 	Prefork bool
+	Timeout time.Duration
+	URI     string
+	Topics  []string
+	// TopicPrefix is prepended to the topic filters of the MQTT gateway.
+	TopicPrefix string
 }
 
 // GetDefaultOptions returns default configuration options for the gateway.
 func GetDefaultOptions() Options {
 	return Options{
-		Port: DefaultGatewayPort,
+		Port:    DefaultGatewayPort,
+		Timeout: DefaultGatewayTimeout,
+		Topics:  []string{DefaultGatewayTopic},
+
+		TopicPrefix: DefaultGatewayTopicPrefix,
 	}
 }
 
@@ -37,3 +60,44 @@ func Prefork(prefork bool) Option {
 		return nil
 	}
 }
+
+// Timeout is an Option to set the deadline of requests. The deadline
+// is propagated to the broker via the user context of the request.
+func Timeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		o.Timeout = timeout
+		return nil
+	}
+}
+
+// URI is an Option to set the server that gateways connect to,
+// such as the MQTT server that is bridged by the MQTT gateway.
+func URI(uri string) Option {
+	return func(o *Options) error {
+		o.URI = uri
+		return nil
+	}
+}
+
+// Topics is an Option to set the topic filters that are bridged by
+// the MQTT gateway. The filters are relative to the topic prefix and
+// by default, all topics below the prefix are bridged.
+func Topics(filters ...string) Option {
+	return func(o *Options) error {
+		o.Topics = filters
+		return nil
+	}
+}
+
+// TopicPrefix is an Option to set the topic level below which the MQTT
+// gateway receives requests, such as `requests/pets/create`. Responses
+// must be published on topics outside of it.
+func TopicPrefix(prefix string) Option {
+	return func(o *Options) error {
+		if prefix == "" {
+			return ErrEmptyTopicPrefix
+		}
+		o.TopicPrefix = prefix
+		return nil
+	}
+}
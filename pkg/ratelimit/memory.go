@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// This file contains a store that keeps the buckets in memory.
+
+const (
+	// DefaultMemorySweepInterval limits how often full buckets are removed.
+	DefaultMemorySweepInterval = 1 * time.Minute
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// Memory is a store that keeps the buckets of a single instance.
+type Memory struct {
+	buckets map[string]*bucket
+	sweptAt time.Time
+	mutex   *sync.Mutex
+}
+
+// NewMemory creates a new in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		sweptAt: time.Now(),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (store *Memory) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return store.take(key, limit, 1), nil
+}
+
+func (store *Memory) Peek(ctx context.Context, key string, limit Limit) (Result, error) {
+	return store.take(key, limit, 0), nil
+}
+
+// take refills the bucket and takes the cost if a token is available.
+func (store *Memory) take(key string, limit Limit, cost float64) Result {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	store.sweep(now)
+
+	b := store.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		store.buckets[key] = b
+	}
+
+	// Refill the tokens since the last request.
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens -= cost
+	}
+
+	result := newResult(limit, allowed, b.tokens)
+	b.full = now.Add(result.Reset)
+
+	return result
+}
+
+// sweep removes buckets that are full again. The mutex must be held.
+func (store *Memory) sweep(now time.Time) {
+	if now.Sub(store.sweptAt) < DefaultMemorySweepInterval {
+		return
+	}
+	store.sweptAt = now
+
+	for key, b := range store.buckets {
+		if now.After(b.full) {
+			delete(store.buckets, key)
+		}
+	}
+}
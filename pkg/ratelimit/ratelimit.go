@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+)
+
+// This file contains a rate limiter based on the token bucket algorithm.
+// Every key owns a bucket that holds up to `Burst` tokens and is refilled
+// with `Burst` tokens per `Period`. A request is allowed if it can take a
+// token from the bucket. Limits are written as `<burst>/<period>`, for
+// example `100/1m`.
+
+var (
+	ErrLimitMalformed = errors.New("ratelimit: limit malformed")
+)
+
+// Limit describes the size and the refill period of a token bucket.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Override applies a different limit to channels matching the pattern.
+type Override struct {
+	Channel string
+	Limit   Limit
+}
+
+// Result describes the state of a bucket after taking a token.
+type Result struct {
+	Limit     Limit
+	Allowed   bool
+	Remaining int
+	// Reset is the duration until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the duration until the next token is available.
+	RetryAfter time.Duration
+}
+
+// Store keeps the state of the buckets.
+type Store interface {
+	// Take takes a token from the bucket if one is available.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Peek checks if a token is available without taking it.
+	Peek(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+type LimiterOptions struct {
+	// Limit is applied to all channels without an override.
+	Limit Limit
+	// Overrides are evaluated in order and the first match wins.
+	Overrides []Override
+	// Store keeps the buckets. It defaults to an in-memory store,
+	// which is not shared between multiple instances.
+	Store Store
+}
+
+// Limiter limits the rate of requests per key and channel.
+type Limiter struct {
+	options *LimiterOptions
+}
+
+// NewLimiter creates a new rate limiter.
+func NewLimiter(opts *LimiterOptions) *Limiter {
+	if opts.Store == nil {
+		opts.Store = NewMemory()
+	}
+
+	return &Limiter{
+		options: opts,
+	}
+}
+
+// Take takes a token from the bucket of the key for the channel. Channels
+// with an override use a separate bucket for each pattern.
+func (limiter *Limiter) Take(ctx context.Context, key string, channel string) (Result, error) {
+	bucket, limit := limiter.bucket(key, channel)
+	return limiter.options.Store.Take(ctx, bucket, limit)
+}
+
+// Peek checks if a token is available in the bucket of the key for the
+// channel without taking it, which allows to only count some requests,
+// such as failed authentications, while rejecting all of them.
+func (limiter *Limiter) Peek(ctx context.Context, key string, channel string) (Result, error) {
+	bucket, limit := limiter.bucket(key, channel)
+	return limiter.options.Store.Peek(ctx, bucket, limit)
+}
+
+// bucket returns the bucket of the key and its limit for the channel.
+func (limiter *Limiter) bucket(key string, channel string) (string, Limit) {
+	for _, override := range limiter.options.Overrides {
+		if broker.MatchChannel(override.Channel, channel) {
+			return key + "|" + override.Channel, override.Limit
+		}
+	}
+
+	return key, limiter.options.Limit
+}
+
+// ParseLimit parses a limit in the format `<burst>/<period>`.
+func ParseLimit(value string) (Limit, error) {
+	fields := strings.Split(value, "/")
+	if len(fields) != 2 {
+		return Limit{}, fmt.Errorf("%w: %s", ErrLimitMalformed, value)
+	}
+
+	burst, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("%w: %s", ErrLimitMalformed, value)
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(fields[1]))
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("%w: %s", ErrLimitMalformed, value)
+	}
+
+	return Limit{Burst: burst, Period: period}, nil
+}
+
+// ParseOverrides parses comma-separated overrides in the
+// format `<channel>=<burst>/<period>`.
+func ParseOverrides(value string) ([]Override, error) {
+	overrides := make([]Override, 0)
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: %s", ErrLimitMalformed, entry)
+		}
+
+		limit, err := ParseLimit(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		overrides = append(overrides, Override{
+			Channel: strings.TrimSpace(fields[0]),
+			Limit:   limit,
+		})
+	}
+
+	return overrides, nil
+}
+
+// rate returns the number of tokens that are refilled per second.
+func (limit Limit) rate() float64 {
+	return float64(limit.Burst) / limit.Period.Seconds()
+}
+
+// newResult describes a bucket with the remaining tokens.
+func newResult(limit Limit, allowed bool, tokens float64) Result {
+	rate := limit.rate()
+
+	result := Result{
+		Limit:     limit,
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Burst) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+
+	return result
+}
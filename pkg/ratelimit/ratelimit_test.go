@@ -0,0 +1,181 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		limit Limit
+		err   error
+	}{
+		{name: "limit", value: "100/1m", limit: Limit{Burst: 100, Period: time.Minute}},
+		{name: "whitespace", value: " 10 / 1s ", limit: Limit{Burst: 10, Period: time.Second}},
+		{name: "missing period", value: "100", err: ErrLimitMalformed},
+		{name: "invalid burst", value: "many/1m", err: ErrLimitMalformed},
+		{name: "zero burst", value: "0/1m", err: ErrLimitMalformed},
+		{name: "negative period", value: "10/-1m", err: ErrLimitMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := ParseLimit(tt.value)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if limit != tt.limit {
+				t.Errorf("expected limit %v, got %v", tt.limit, limit)
+			}
+		})
+	}
+}
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := ParseOverrides("v1.mails.create=10/1m, v1.*.find=100/1s,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Override{
+		{Channel: "v1.mails.create", Limit: Limit{Burst: 10, Period: time.Minute}},
+		{Channel: "v1.*.find", Limit: Limit{Burst: 100, Period: time.Second}},
+	}
+	if len(overrides) != len(expected) {
+		t.Fatalf("expected %d overrides, got %d", len(expected), len(overrides))
+	}
+	for i := range expected {
+		if overrides[i] != expected[i] {
+			t.Errorf("expected override %v, got %v", expected[i], overrides[i])
+		}
+	}
+
+	if _, err := ParseOverrides("v1.mails.create"); !errors.Is(err, ErrLimitMalformed) {
+		t.Errorf("expected error %v, got %v", ErrLimitMalformed, err)
+	}
+}
+
+func TestStores(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{name: "memory", store: NewMemory()},
+		{name: "redis", store: NewRedis(&RedisOptions{Client: client})},
+	}
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			ctx := context.Background()
+			limit := Limit{Burst: 2, Period: time.Hour}
+
+			// Peeking does not take a token.
+			for i := 0; i < 3; i++ {
+				result, err := s.store.Peek(ctx, "peek", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Allowed || result.Remaining != 2 {
+					t.Fatalf("expected 2 remaining tokens, got %+v", result)
+				}
+			}
+
+			for i, remaining := range []int{1, 0} {
+				result, err := s.store.Take(ctx, "take", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Allowed || result.Remaining != remaining {
+					t.Fatalf("expected request %d to be allowed with %d remaining tokens, got %+v", i, remaining, result)
+				}
+			}
+
+			for _, take := range []func(context.Context, string, Limit) (Result, error){s.store.Peek, s.store.Take} {
+				result, err := take(ctx, "take", limit)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 30*time.Minute {
+					t.Fatalf("expected request to be rejected for up to 30m, got %+v", result)
+				}
+			}
+
+			// Other keys own separate buckets.
+			result, err := s.store.Take(ctx, "other", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Fatalf("expected request of other key to be allowed, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestStoreRefill(t *testing.T) {
+	store := NewMemory()
+	limit := Limit{Burst: 1, Period: 50 * time.Millisecond}
+
+	if result, _ := store.Take(context.Background(), "key", limit); !result.Allowed {
+		t.Fatalf("expected first request to be allowed, got %+v", result)
+	}
+	if result, _ := store.Take(context.Background(), "key", limit); result.Allowed {
+		t.Fatalf("expected second request to be rejected, got %+v", result)
+	}
+
+	time.Sleep(limit.Period)
+
+	if result, _ := store.Take(context.Background(), "key", limit); !result.Allowed {
+		t.Fatalf("expected request to be allowed after refill, got %+v", result)
+	}
+}
+
+func TestLimiterOverrides(t *testing.T) {
+	limiter := NewLimiter(&LimiterOptions{
+		Limit: Limit{Burst: 1, Period: time.Hour},
+		Overrides: []Override{
+			{Channel: "v1.mails.create", Limit: Limit{Burst: 2, Period: time.Hour}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		channel string
+		allowed bool
+	}{
+		{name: "default", channel: "v1.pets.find", allowed: true},
+		{name: "default exhausted", channel: "v1.pets.read"},
+		// Overrides use separate buckets.
+		{name: "override", channel: "v1.mails.create", allowed: true},
+		{name: "override burst", channel: "v1.mails.create", allowed: true},
+		{name: "override exhausted", channel: "v1.mails.create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := limiter.Take(context.Background(), "user:alice", tt.channel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed != tt.allowed {
+				t.Errorf("expected allowed to be %v, got %+v", tt.allowed, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// This file contains a store that keeps the buckets in Redis, such
+// that multiple instances of a gateway enforce the same limit. The
+// bucket is updated atomically via a script, which uses the clock of
+// the Redis server to avoid skew between the instances.
+
+const (
+	DefaultRedisPrefix = "ratelimit:"
+)
+
+var takeScript = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + tonumber(time[2]) / 1000
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+local updated = tonumber(redis.call("HGET", KEYS[1], "updated"))
+if tokens == nil or updated == nil then
+  tokens = burst
+  updated = now
+end
+
+tokens = math.min(burst, tokens + (now - updated) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - cost
+  allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+
+return { allowed, tostring(tokens) }
+`)
+
+type RedisOptions struct {
+	Client *redis.Client
+	// Prefix is prepended to the keys of the buckets.
+	Prefix string
+}
+
+// Redis is a store that shares the buckets between instances.
+type Redis struct {
+	options *RedisOptions
+}
+
+// NewRedis creates a new store that uses the Redis client.
+func NewRedis(opts *RedisOptions) *Redis {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultRedisPrefix
+	}
+
+	return &Redis{
+		options: opts,
+	}
+}
+
+func (store *Redis) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return store.take(ctx, key, limit, 1)
+}
+
+func (store *Redis) Peek(ctx context.Context, key string, limit Limit) (Result, error) {
+	return store.take(ctx, key, limit, 0)
+}
+
+// take refills the bucket and takes the cost if a token is available.
+func (store *Redis) take(ctx context.Context, key string, limit Limit, cost int) (Result, error) {
+	// The rate is passed in tokens per millisecond.
+	rate := limit.rate() / 1000
+
+	values, err := takeScript.Run(ctx, store.options.Client, []string{store.options.Prefix + key}, limit.Burst, rate, cost).Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	allowed, _ := values[0].(int64)
+	encodedTokens, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(encodedTokens, 64)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(limit, allowed == 1, tokens), nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// This file contains the admin listener of a service, which allows
+// orchestrators, such as Kubernetes, to probe the service:
+//
+//   GET /healthz  responds with 200 as long as the service is alive.
+//   GET /readyz   responds with 200 if the service is able to handle
+//                 traffic and with 503 otherwise.
+//   GET /metrics  responds with the metrics in the Prometheus format.
+//
+// A service is ready if its broker is connected, its gateway is
+// listening, all custom health checks pass and it is not shutting down.
+
+const (
+	// DefaultHealthCheckTimeout limits how long a health check may take.
+	DefaultHealthCheckTimeout = 1 * time.Second
+
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+)
+
+var (
+	ErrShuttingDown = errors.New("service: shutting down")
+)
+
+// HealthCheck describes the function signature of custom health
+// checks, which return an error if the service is not ready.
+type HealthCheck func(context.Context) error
+
+// Health is the report of the admin endpoints.
+type Health struct {
+	Status string `json:"status"`
+	// Checks contains the status or the error of each check.
+	Checks   map[string]string `json:"checks,omitempty"`
+	Channels []string          `json:"channels,omitempty"`
+}
+
+// HealthCheck registers a custom health check, which must pass
+// for the service to be ready.
+func (svc *Service) HealthCheck(name string, check HealthCheck) *Service {
+	svc.healthChecks[name] = check
+
+	// Return the service pointer to allow method chaining.
+	return svc
+}
+
+// Ready checks if the service is able to handle traffic.
+func (svc *Service) Ready(ctx context.Context) *Health {
+	checks := make(map[string]error)
+	if atomic.LoadInt32(&svc.stopping) == 1 {
+		checks["service"] = ErrShuttingDown
+	}
+	if svc.Broker != nil {
+		checks["broker"] = nil
+		if !svc.Broker.Connected() {
+			checks["broker"] = ErrBrokerDisconnected
+		}
+	}
+	if svc.Gateway != nil {
+		checks["gateway"] = nil
+		if !svc.Gateway.Listening() {
+			checks["gateway"] = ErrGatewayNotListening
+		}
+	}
+	for name, check := range svc.healthChecks {
+		checkCtx, cancel := context.WithTimeout(ctx, DefaultHealthCheckTimeout)
+		checks[name] = check(checkCtx)
+		cancel()
+	}
+
+	health := &Health{
+		Status:   HealthStatusOK,
+		Checks:   make(map[string]string),
+		Channels: svc.channels,
+	}
+	for name, err := range checks {
+		if err != nil {
+			health.Status = HealthStatusFailed
+			health.Checks[name] = err.Error()
+			continue
+		}
+		health.Checks[name] = HealthStatusOK
+	}
+
+	return health
+}
+
+// listenAdmin exposes the admin endpoints in the background.
+func (svc *Service) listenAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, &Health{Status: HealthStatusOK})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, svc.Ready(r.Context()))
+	})
+	mux.Handle("/metrics", promhttp.HandlerFor(svc.Metrics.Registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", ":"+svc.Config.AdminPort)
+	if err != nil {
+		svc.Logger.Fatal().Err(err).Msg("Failed running admin listener")
+	}
+
+	svc.admin = &http.Server{Handler: mux}
+	go func() {
+		if err := svc.admin.Serve(listener); err != nil && err != http.ErrServerClosed {
+			svc.Logger.Fatal().Err(err).Msg("Failed running admin listener")
+		}
+	}()
+
+	svc.Logger.Info().Msgf("Admin online: %s/tcp", svc.Config.AdminPort)
+}
+
+// writeHealth responds with the health report. Failed
+// reports are sent with the status 503.
+func writeHealth(w http.ResponseWriter, health *Health) {
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != HealthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+)
+
+const (
+	// ChannelSuffixSuccess is appended to a channel to broadcast
+	// that the channel handler completed successfully.
+	ChannelSuffixSuccess = "success"
+	// ChannelSuffixFailure is appended to a channel to broadcast
+	// that the channel handler returned an error.
+	ChannelSuffixFailure = "failure"
+)
+
+// Broadcast enables or disables broadcasting the outcome of the channel
+// handler. Broadcasting is disabled for all channels by default, as the
+// subscribers of the broadcasts may be clients of the gateways.
+func (svc *Service) Broadcast(channel string, enabled bool) *Service {
+	svc.broadcastsMutex.Lock()
+	svc.broadcasts[channel] = enabled
+	svc.broadcastsMutex.Unlock()
+
+	// Return the service pointer to allow method chaining.
+	return svc
+}
+
+// broadcast wraps the channel handler to publish a cloud event to
+// `<channel>.success` or `<channel>.failure` after it completed.
+func (svc *Service) broadcast(channel string, channelHandler ChannelHandler) ChannelHandler {
+	// Wildcard channels do not have a canonical name to broadcast on.
+	// Broadcasting would also cause them to receive their own events.
+	if strings.ContainsAny(channel, "*>") {
+		return channelHandler
+	}
+
+	return func(ctx *Context) error {
+		handlerErr := channelHandler(ctx)
+
+		svc.broadcastsMutex.RLock()
+		enabled := svc.broadcasts[channel]
+		svc.broadcastsMutex.RUnlock()
+		if !enabled {
+			return handlerErr
+		}
+
+		// Only the final outcome of redelivered events is broadcast.
+		if handlerErr != nil && ctx.Retryable && !ClientError(handlerErr) {
+			return handlerErr
+		}
+
+		event := cloudevents.NewEvent()
+		event.SetID(uuid.NewString())
+		event.SetSource(svc.Config.Name)
+		event.SetExtension(ExtensionCorrelationID, ctx.Cloudevent.ID())
+
+		if handlerErr != nil {
+			// Broadcasts are forwarded to the subscribers of the gateways,
+			// so unexpected errors must not reveal any internals.
+			svcErr := errs.UnexpectedError
+			if !errors.As(handlerErr, &svcErr) {
+				ctx.Logger().Error().Err(handlerErr).Msg("Broadcasting unexpected error")
+			}
+
+			event.SetType(channel + "." + ChannelSuffixFailure)
+			event.SetData(cloudevents.ApplicationJSON, svcErr)
+		} else {
+			// The data of the handled event is not broadcast, as it may
+			// be confidential, such as the body of a mail. Subscribers
+			// correlate the broadcast with the event via its ID instead.
+			event.SetType(channel + "." + ChannelSuffixSuccess)
+		}
+
+		InjectTraceContext(ctx.UserContext(), &event)
+
+		if err := svc.Broker.PublishEvent(event.Type(), &event); err != nil {
+			ctx.Logger().Warn().Err(err).Msgf("Failed to broadcast: %s", event.Type())
+		}
+
+		return handlerErr
+	}
+}
@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicklasfrahm/showcases/pkg/broker"
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+	"github.com/nicklasfrahm/showcases/pkg/service"
+)
+
+func TestBroadcast(t *testing.T) {
+	svc := service.New(service.Config{Name: "mail"})
+	svc.UseBroker(broker.NewMemory(&broker.MemoryOptions{Bus: broker.NewMemoryBus(), RequestTimeout: 50 * time.Millisecond}))
+
+	svc.BrokerChannel("mails.create", func(ctx *service.Context) error {
+		return ctx.Reply(map[string]string{"body": "secret"})
+	})
+	svc.BrokerChannel("mails.delete", func(ctx *service.Context) error {
+		return errs.InvalidData
+	})
+	svc.BrokerChannel("pets.create", func(ctx *service.Context) error {
+		return nil
+	})
+	svc.Broadcast("mails.create", true)
+	svc.Broadcast("mails.delete", true)
+
+	if err := svc.Broker.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Broker.Disconnect()
+
+	broadcasts := make(chan *service.Context, 8)
+	for _, pattern := range []string{"mails.*.*", "pets.*.*"} {
+		subscription, err := svc.Broker.Watch(pattern, func(ctx *service.Context) error {
+			broadcasts <- ctx
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer subscription.Unsubscribe()
+	}
+
+	tests := []struct {
+		name    string
+		channel string
+		// broadcast is the expected channel of the broadcast, if any.
+		broadcast string
+		data      string
+	}{
+		{name: "success without data", channel: "mails.create", broadcast: "mails.create.success"},
+		{name: "failure with error", channel: "mails.delete", broadcast: "mails.delete.failure", data: `{"title":"Unprocessable Entity","status":422,"message":"Invalid Data"}`},
+		{name: "disabled by default", channel: "pets.create"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.Broker.Publish(tt.channel, map[string]string{"body": "secret"}); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case ctx := <-broadcasts:
+				if ctx.Channel != tt.broadcast {
+					t.Fatalf("expected broadcast on %q, got %s", tt.broadcast, ctx.Channel)
+				}
+				if string(ctx.Cloudevent.Data()) != tt.data {
+					t.Errorf("expected data %q, got %q", tt.data, ctx.Cloudevent.Data())
+				}
+				if ctx.Cloudevent.Extensions()[service.ExtensionCorrelationID] == nil {
+					t.Errorf("expected broadcast to reference the handled event")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.broadcast != "" {
+					t.Fatalf("expected broadcast on %s", tt.broadcast)
+				}
+			}
+		})
+	}
+}
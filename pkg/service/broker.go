@@ -1,20 +1,64 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+)
+
+const (
+	// ExtensionReply is the cloud event extension that contains
+	// the reply channel of a request.
+	ExtensionReply = "replyto"
+	// ExtensionCorrelationID is the cloud event extension that
+	// contains the ID of the request event a reply belongs to.
+	ExtensionCorrelationID = "correlationid"
+
+	// EventTypeResponse is the type of successful replies.
+	EventTypeResponse = "response"
+	// EventTypeError is the type of replies that contain an error.
+	EventTypeError = "error"
 )
 
 var (
 	ErrNoBrokerConfigured = errors.New("broker: no broker configured")
 	ErrIllegalUnsubscribe = errors.New("broker: unsubscribe without prior subscription illegal")
+	ErrBrokerDisconnected = errors.New("broker: not connected")
+	ErrRequestTimeout     = errors.New("broker: request timed out")
+	ErrNoResponders       = errors.New("broker: no responders available")
+
+	ErrPersistenceUnsupported = errors.New("broker: persistent channels unsupported")
 )
 
 // Context is the structure of the data that is passed to a channel.
 type Context struct {
 	Service    *Service
 	Cloudevent *cloudevents.Event
+	// Channel is the channel the event was received on, which is
+	// concrete even if the handler subscribed to a pattern.
+	Channel string
+	// Stream is only set for channels that accept streams.
+	Stream Stream
+	// Retryable is set if the event is redelivered when the channel
+	// handler fails with an error other than a client error. Such errors
+	// are not replied, because a later delivery may still succeed.
+	Retryable bool
+
+	replied     bool
+	extensions  map[string]interface{}
+	userContext context.Context
+}
+
+// ClientError checks if the error is caused by the client, which is
+// the case for service errors with a status below 500. Handling the
+// same event again will therefore never succeed.
+func ClientError(err error) bool {
+	var svcErr *errs.ServiceError
+	return errors.As(err, &svcErr) && svcErr.Status < 500
 }
 
 // Channel contains basic information about a service channel.
@@ -23,10 +67,26 @@ type Channel struct {
 	Subscribers int    `json:"subscribers"`
 }
 
+// PersistentChannel contains the configuration of a channel,
+// whose events are stored by the broker until they are acknowledged.
+// An event is acknowledged if the channel handler does not return an
+// error. Otherwise it is redelivered until MaxDeliver is reached,
+// unless the error is a client error, which will never succeed.
+type PersistentChannel struct {
+	Name       string
+	MaxDeliver int
+	AckWait    time.Duration
+}
+
 // ChannelHandler describes the function signature of the functions
 // that implements the logic for the channel.
 type ChannelHandler func(*Context) error
 
+// Subscription is a subscription that was created via `Watch()`.
+type Subscription interface {
+	Unsubscribe() error
+}
+
 // Broker is an abstraction to allow provider agnostic interactions
 // with a event broker or message queue.
 type Broker interface {
@@ -34,12 +94,38 @@ type Broker interface {
 
 	Subscribe(string, ChannelHandler) error
 	Unsubscribe(string) error
+	// Watch subscribes to a channel without a queue group, such that
+	// every watcher receives all events. Unlike channels of Subscribe,
+	// watched channels are not registered, which makes them suitable
+	// for short-lived subscriptions, for example of gateway clients.
+	Watch(string, ChannelHandler) (Subscription, error)
 	Publish(string, interface{}) error
+	// PublishContext publishes the data unless the context is done.
+	PublishContext(context.Context, string, interface{}) error
+	// PublishEvent publishes a cloud event as is, which
+	// allows to preserve or set arbitrary attributes.
+	PublishEvent(string, *cloudevents.Event) error
 
 	Request(string, interface{}) (*Context, error)
+	// RequestContext sends a request, which is aborted once the
+	// context is done. The options may override the timeout of
+	// the broker and configure retries.
+	RequestContext(context.Context, string, interface{}, ...RequestOption) (*Context, error)
+
+	// Persist declares a persistent channel. It must be called
+	// before subscribing to the channel.
+	Persist(PersistentChannel) error
 
-	// TODO: Abstract message queue / stream API.
+	// SubscribeStream accepts streams opened on a channel. The
+	// stream is passed to the channel handler via the context.
+	SubscribeStream(string, ChannelHandler) error
+	// OpenStream opens a stream to a service subscribed to the
+	// channel. The data is passed to the peer as cloud event. The
+	// context only aborts opening and not the opened stream.
+	OpenStream(context.Context, string, interface{}) (Stream, error)
 
 	Connect() error
 	Disconnect() error
+	// Connected reports whether the broker is connected.
+	Connected() bool
 }
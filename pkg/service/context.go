@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+)
+
+// The following extensions of a reply describe how gateways should
+// represent it. Gateways that do not support them ignore them.
+const (
+	ExtensionHTTPStatus   = "httpstatus"
+	ExtensionLocation     = "location"
+	ExtensionETag         = "etag"
+	ExtensionLastModified = "lastmodified"
+)
+
+// SetStatus sets the HTTP status code of the reply.
+func (ctx *Context) SetStatus(status int) *Context {
+	return ctx.setExtension(ExtensionHTTPStatus, status)
+}
+
+// SetLocation sets the location of a created resource.
+func (ctx *Context) SetLocation(location string) *Context {
+	return ctx.setExtension(ExtensionLocation, location)
+}
+
+// SetETag sets the entity tag of the resource in the reply.
+func (ctx *Context) SetETag(etag string) *Context {
+	return ctx.setExtension(ExtensionETag, etag)
+}
+
+// SetLastModified sets the modification time of the resource in the reply.
+func (ctx *Context) SetLastModified(lastModified time.Time) *Context {
+	return ctx.setExtension(ExtensionLastModified, lastModified)
+}
+
+// ReplyChannel returns the channel the reply to the event should
+// be sent to. It is empty if the event was published without
+// expecting a reply.
+func (ctx *Context) ReplyChannel() string {
+	reply, _ := ctx.Cloudevent.Extensions()[ExtensionReply].(string)
+	return reply
+}
+
+// Reply sends the data to the requester. It does nothing if the
+// event was published without expecting a reply.
+func (ctx *Context) Reply(data interface{}) error {
+	return ctx.reply(EventTypeResponse, data)
+}
+
+// ReplyError sends the error to the requester. Errors that are not
+// of the type errs.ServiceError are replaced with an unexpected error
+// to prevent leaking internal information.
+func (ctx *Context) ReplyError(err error) error {
+	svcErr := errs.UnexpectedError
+	errors.As(err, &svcErr)
+
+	return ctx.reply(EventTypeError, svcErr)
+}
+
+// Respond sends the error to the requester if it is not nil.
+// Otherwise the data is sent.
+func (ctx *Context) Respond(data interface{}, err error) error {
+	if err != nil {
+		return ctx.ReplyError(err)
+	}
+
+	return ctx.Reply(data)
+}
+
+// Err returns the error contained in a reply of the type error.
+// It returns nil for all other events.
+func (ctx *Context) Err() error {
+	if ctx.Cloudevent.Type() != EventTypeError {
+		return nil
+	}
+
+	svcErr := new(errs.ServiceError)
+	if err := ctx.Cloudevent.DataAs(svcErr); err != nil {
+		return errs.UnexpectedError
+	}
+
+	return svcErr
+}
+
+// replyError wraps the channel handler to reply with the returned
+// error, unless the channel handler already replied itself.
+func (svc *Service) replyError(channel string, channelHandler ChannelHandler) ChannelHandler {
+	// Wildcard channels observe the requests of other channels, whose
+	// handlers are responsible for replying to the requester.
+	if strings.ContainsAny(channel, "*>") {
+		return channelHandler
+	}
+
+	return func(ctx *Context) error {
+		handlerErr := channelHandler(ctx)
+		// Errors of events that are redelivered are only replied once the
+		// last delivery failed, as the requester would otherwise receive an
+		// error for an event that may still succeed.
+		if ctx.Retryable && !ClientError(handlerErr) {
+			return handlerErr
+		}
+
+		if handlerErr != nil && !ctx.replied {
+			if err := ctx.ReplyError(handlerErr); err != nil {
+				ctx.Logger().Warn().Err(err).Msg("Failed to reply")
+			}
+		}
+
+		return handlerErr
+	}
+}
+
+// reply assembles a reply event, which references the request
+// event via the correlation ID, and publishes it.
+func (ctx *Context) reply(eventType string, data interface{}) error {
+	reply := ctx.ReplyChannel()
+	if reply == "" {
+		return nil
+	}
+
+	event := cloudevents.NewEvent()
+	event.SetID(uuid.NewString())
+	event.SetSource(ctx.Service.Config.Name)
+	event.SetType(eventType)
+	event.SetExtension(ExtensionCorrelationID, ctx.Cloudevent.ID())
+	for name, value := range ctx.extensions {
+		event.SetExtension(name, value)
+	}
+	if err := event.SetData(cloudevents.ApplicationJSON, data); err != nil {
+		return err
+	}
+
+	ctx.replied = true
+
+	InjectTraceContext(ctx.UserContext(), &event)
+
+	return ctx.Service.Broker.PublishEvent(reply, &event)
+}
+
+// setExtension stores an extension that is added to the reply.
+func (ctx *Context) setExtension(name string, value interface{}) *Context {
+	if ctx.extensions == nil {
+		ctx.extensions = make(map[string]interface{})
+	}
+	ctx.extensions[name] = value
+
+	return ctx
+}
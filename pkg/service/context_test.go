@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+
+	"github.com/nicklasfrahm/showcases/pkg/errs"
+)
+
+// recordingBroker records the types of the published events.
+type recordingBroker struct {
+	Broker
+	published []string
+}
+
+func (broker *recordingBroker) PublishEvent(channel string, event *cloudevents.Event) error {
+	broker.published = append(broker.published, event.Type())
+	return nil
+}
+
+func TestReplyErrorRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		replied   bool
+	}{
+		{name: "last delivery", err: errors.New("failed"), replied: true},
+		{name: "retryable delivery", err: errors.New("failed"), retryable: true},
+		{name: "client error of retryable delivery", err: errs.InvalidData, retryable: true, replied: true},
+		{name: "success of retryable delivery", retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &recordingBroker{}
+			svc := &Service{Config: Config{Name: "mail"}, Broker: broker}
+
+			request := cloudevents.NewEvent()
+			request.SetExtension(ExtensionReply, "_INBOX.reply")
+
+			handler := svc.replyError("mails.create", func(ctx *Context) error {
+				return tt.err
+			})
+			handler(&Context{Service: svc, Cloudevent: &request, Retryable: tt.retryable})
+
+			if replied := len(broker.published) > 0; replied != tt.replied {
+				t.Errorf("expected replied %v, got %v", tt.replied, replied)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	// ExtensionAuthSubject is the cloud event extension that contains
+	// the authenticated caller, on whose behalf the event was published.
+	ExtensionAuthSubject = "authsubject"
+	// ExtensionAuthClaims is the cloud event extension that contains
+	// the validated claims of the caller encoded as JSON.
+	ExtensionAuthClaims = "authclaims"
+)
+
+type extensionsKey struct{}
+
+// WithExtension returns a copy of the context that carries the cloud
+// event extension. Brokers add it to all events published with the
+// returned context, which allows to propagate data between services.
+func WithExtension(ctx context.Context, name string, value interface{}) context.Context {
+	extensions := make(map[string]interface{})
+	for key, val := range ExtensionsFromContext(ctx) {
+		extensions[key] = val
+	}
+	extensions[name] = value
+
+	return context.WithValue(ctx, extensionsKey{}, extensions)
+}
+
+// ExtensionsFromContext returns the cloud event extensions stored in the context.
+func ExtensionsFromContext(ctx context.Context) map[string]interface{} {
+	extensions, _ := ctx.Value(extensionsKey{}).(map[string]interface{})
+	return extensions
+}
+
+// Subject returns the authenticated caller, on whose behalf the
+// event was published. It is empty for anonymous events.
+func (ctx *Context) Subject() string {
+	subject, _ := ctx.Cloudevent.Extensions()[ExtensionAuthSubject].(string)
+	return subject
+}
+
+// Claims returns the validated claims of the caller. It is nil if
+// the caller was not authenticated via a token.
+func (ctx *Context) Claims() map[string]interface{} {
+	encoded, _ := ctx.Cloudevent.Extensions()[ExtensionAuthClaims].(string)
+	if encoded == "" {
+		return nil
+	}
+
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(encoded), &claims); err != nil {
+		return nil
+	}
+
+	return claims
+}
@@ -1,11 +1,13 @@
 package service
 
 import (
+	"context"
 	"errors"
 )
 
 var (
 	ErrNoGatewayConfigured = errors.New("gateway: no gateway configured")
+	ErrGatewayNotListening = errors.New("gateway: not listening")
 )
 
 // Request is an abstract interface that provides access to
@@ -29,4 +31,10 @@ type Gateway interface {
 	Route(RequestHandler)
 
 	Listen()
+	// Listening reports whether the gateway accepts traffic.
+	Listening() bool
+
+	// Shutdown stops accepting traffic and waits for in-flight
+	// requests to complete until the context is done.
+	Shutdown(context.Context) error
 }
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// This file contains the instrumentation of channel handlers and brokers,
+// which records the metrics and the spans and sets up the loggers of a service.
+
+// channelNoun matches the hierarchy levels of a channel that are nouns
+// (see: docs/concepts.md), prefixes, such as `_INBOX`, or versions.
+var channelNoun = regexp.MustCompile(`^([A-Za-z_-]+|v[0-9]+)$`)
+
+// NormalizeChannel replaces the hierarchy levels of a channel that are
+// not nouns, such as IDs, with a single-level wildcard. It is used for
+// span names and metric labels, whose cardinality must be bounded.
+func NormalizeChannel(channel string) string {
+	tokens := strings.Split(channel, ".")
+	for i, token := range tokens {
+		if token != "*" && token != ">" && !channelNoun.MatchString(token) {
+			tokens[i] = "*"
+		}
+	}
+
+	return strings.Join(tokens, ".")
+}
+
+// instrument wraps the channel handler to record its calls by
+// the registered channel, which may contain wildcards.
+func (svc *Service) instrument(channel string, channelHandler ChannelHandler) ChannelHandler {
+	return func(ctx *Context) error {
+		span := svc.startHandlerSpan(channel, ctx)
+		defer span.End()
+		svc.startHandlerLogger(ctx)
+
+		start := time.Now()
+		err := channelHandler(ctx)
+
+		svc.Metrics.HandlerDuration.WithLabelValues(channel).Observe(time.Since(start).Seconds())
+		svc.Metrics.HandlerCalls.WithLabelValues(channel, result(err)).Inc()
+		endSpan(span, err)
+
+		return err
+	}
+}
+
+// endSpan records the error of the operation in the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// instrumentedBroker records the publishes and requests of the broker.
+type instrumentedBroker struct {
+	Broker
+
+	service *Service
+}
+
+// start starts the span of the operation on the channel.
+func (b *instrumentedBroker) start(ctx context.Context, operation string, channel string) (context.Context, trace.Span) {
+	kind := trace.SpanKindProducer
+	if operation == OperationRequest {
+		kind = trace.SpanKindClient
+	}
+
+	// Channels may contain IDs, which are only recorded as attribute.
+	return b.service.Tracer.Start(ctx, NormalizeChannel(channel)+" "+operation,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(semconv.MessagingDestinationName(channel)),
+	)
+}
+
+// observe records the duration and the result of the operation.
+func (b *instrumentedBroker) observe(operation string, start time.Time, span trace.Span, err error) {
+	metrics := b.service.Metrics
+	metrics.BrokerDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+	if operation == OperationRequest && errors.Is(err, ErrRequestTimeout) {
+		metrics.BrokerTimeouts.Inc()
+	}
+
+	endSpan(span, err)
+	span.End()
+}
+
+func (b *instrumentedBroker) Publish(channel string, data interface{}) error {
+	return b.PublishContext(context.Background(), channel, data)
+}
+
+func (b *instrumentedBroker) PublishContext(ctx context.Context, channel string, data interface{}) error {
+	ctx, span := b.start(ctx, OperationPublish, channel)
+	start := time.Now()
+	err := b.Broker.PublishContext(ctx, channel, data)
+	b.observe(OperationPublish, start, span, err)
+
+	return err
+}
+
+// PublishEvent continues the trace of the event and replaces its trace
+// context with the span of the publish. The span is named after the type
+// of the event, because replies are published on private channels.
+func (b *instrumentedBroker) PublishEvent(channel string, event *cloudevents.Event) error {
+	ctx, span := b.start(ExtractTraceContext(context.Background(), event), OperationPublish, event.Type())
+	InjectTraceContext(ctx, event)
+
+	start := time.Now()
+	err := b.Broker.PublishEvent(channel, event)
+	b.observe(OperationPublish, start, span, err)
+
+	return err
+}
+
+func (b *instrumentedBroker) Request(channel string, data interface{}) (*Context, error) {
+	return b.RequestContext(context.Background(), channel, data)
+}
+
+func (b *instrumentedBroker) RequestContext(ctx context.Context, channel string, data interface{}, options ...RequestOption) (*Context, error) {
+	ctx, span := b.start(ctx, OperationRequest, channel)
+	start := time.Now()
+	res, err := b.Broker.RequestContext(ctx, channel, data, options...)
+	b.observe(OperationRequest, start, span, err)
+
+	return res, err
+}
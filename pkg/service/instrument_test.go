@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestNormalizeChannel(t *testing.T) {
+	tests := []struct {
+		name       string
+		channel    string
+		normalized string
+	}{
+		{name: "resource list", channel: "v1.pets.find", normalized: "v1.pets.find"},
+		{name: "numeric id", channel: "v1.pets.42.read", normalized: "v1.pets.*.read"},
+		{name: "uuid", channel: "v1.pets.3f2b8c1e-5d7a-4e9b-8f1a-2c6d9e0b7a4f.toys.find", normalized: "v1.pets.*.toys.find"},
+		{name: "inbox", channel: "_INBOX.kHd8w2Qz1", normalized: "_INBOX.*"},
+		{name: "wildcards", channel: "v1.*.>", normalized: "v1.*.>"},
+		{name: "hyphenated noun", channel: "mail-providers.find", normalized: "mail-providers.find"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if normalized := NormalizeChannel(tt.channel); normalized != tt.normalized {
+				t.Errorf("expected %s, got %s", tt.normalized, normalized)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// This file contains the logging of a service. Each service has its own
+// logger, which writes to stderr, such that the global logger of zerolog
+// is not modified. Channel handlers and gateway middlewares use child
+// loggers, which are enriched with the channel, the event, the trace
+// and the user, such that the logs of a request can be correlated.
+
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+
+	// DefaultLogLevel is used if no log level is configured.
+	DefaultLogLevel = zerolog.InfoLevel
+
+	LogFieldService = "service"
+	LogFieldChannel = "channel"
+	LogFieldEventID = "event_id"
+	LogFieldTraceID = "trace_id"
+	LogFieldUser    = "user"
+)
+
+var (
+	ErrUnknownLogFormat = errors.New("service: unknown log format")
+	ErrUnknownLogLevel  = errors.New("service: unknown log level")
+)
+
+// loggerKey is the context key of the logger.
+type loggerKey struct{}
+
+// newLogger creates the logger of the service. If the configuration is
+// invalid, a console logger is returned alongside the error, such that
+// the error can still be logged.
+func newLogger(config Config) (zerolog.Logger, error) {
+	var writer io.Writer = zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: time.RFC3339,
+	}
+	fallback := zerolog.New(writer).Level(DefaultLogLevel).With().Timestamp().Logger()
+
+	switch config.LogFormat {
+	case "", LogFormatConsole:
+	case LogFormatJSON:
+		writer = os.Stderr
+	default:
+		return fallback, ErrUnknownLogFormat
+	}
+
+	level := DefaultLogLevel
+	if config.LogLevel != "" {
+		parsed, err := zerolog.ParseLevel(config.LogLevel)
+		if err != nil || parsed == zerolog.NoLevel {
+			return fallback, ErrUnknownLogLevel
+		}
+		level = parsed
+	}
+
+	fields := zerolog.New(writer).Level(level).With().Timestamp()
+	if config.LogFormat == LogFormatJSON {
+		// Console logs are read by humans, who know the service.
+		fields = fields.Str(LogFieldService, config.Name)
+	}
+	logger := fields.Logger()
+
+	// Only debug and info logs are sampled, as warnings and
+	// errors are rare and should never be dropped.
+	if config.LogSampling > 1 {
+		sampler := &zerolog.BasicSampler{N: config.LogSampling}
+		logger = logger.Sample(zerolog.LevelSampler{
+			TraceSampler: sampler,
+			DebugSampler: sampler,
+			InfoSampler:  sampler,
+		})
+	}
+
+	return logger, nil
+}
+
+// WithLogger returns a copy of the context that carries the logger.
+func WithLogger(ctx context.Context, logger *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger of the context or nil.
+func LoggerFromContext(ctx context.Context) *zerolog.Logger {
+	logger, _ := ctx.Value(loggerKey{}).(*zerolog.Logger)
+	return logger
+}
+
+// Logger returns the logger of the channel handler, which contains
+// the channel, the event ID, the trace ID and the user of the event.
+func (ctx *Context) Logger() *zerolog.Logger {
+	if logger := LoggerFromContext(ctx.UserContext()); logger != nil {
+		return logger
+	}
+
+	return ctx.Service.Logger
+}
+
+// Logger returns the logger of the request, which is enriched by the
+// middlewares of the gateway, for example with the channel and the user.
+func (r *Request) Logger() *zerolog.Logger {
+	if c, ok := r.Context.(interface{ UserContext() context.Context }); ok {
+		if logger := LoggerFromContext(c.UserContext()); logger != nil {
+			return logger
+		}
+	}
+
+	return r.Service.Logger
+}
+
+// startHandlerLogger stores a child logger in the context of the channel
+// handler, which is enriched with the information of the event.
+func (svc *Service) startHandlerLogger(ctx *Context) {
+	fields := svc.Logger.With().
+		Str(LogFieldChannel, ctx.Cloudevent.Type()).
+		Str(LogFieldEventID, ctx.Cloudevent.ID())
+	if traceID := TraceID(ctx.UserContext()); traceID != "" {
+		fields = fields.Str(LogFieldTraceID, traceID)
+	}
+	if subject := ctx.Subject(); subject != "" {
+		fields = fields.Str(LogFieldUser, subject)
+	}
+	logger := fields.Logger()
+
+	ctx.userContext = WithLogger(ctx.UserContext(), &logger)
+}
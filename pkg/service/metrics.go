@@ -0,0 +1,110 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// This file contains the metrics of a service, which are exposed in
+// the Prometheus format at `/metrics` on the admin listener. Each service
+// uses its own registry, such that services may share a process. Custom
+// metrics, for example of a channel handler, can be added to the registry.
+
+const (
+	MetricsNamespace = "showcases"
+
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	OperationPublish = "publish"
+	OperationRequest = "request"
+)
+
+// Metrics contains the registry and the collectors of a service.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HandlerCalls    *prometheus.CounterVec
+	HandlerDuration *prometheus.HistogramVec
+
+	BrokerDuration *prometheus.HistogramVec
+	BrokerTimeouts prometheus.Counter
+	// BrokerReconnects is only recorded by brokers that reconnect.
+	BrokerReconnects prometheus.Counter
+
+	// GatewayRequests and GatewayDuration are recorded by gateway
+	// middlewares, because the channel is derived by them.
+	GatewayRequests *prometheus.CounterVec
+	GatewayDuration *prometheus.HistogramVec
+}
+
+// newMetrics creates the collectors and registers them.
+func newMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+
+		HandlerCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "channel_handler_calls_total",
+			Help:      "Number of channel handler calls by channel and result.",
+		}, []string{"channel", "result"}),
+		HandlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "channel_handler_duration_seconds",
+			Help:      "Duration of channel handler calls by channel.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"channel"}),
+
+		BrokerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "broker_operation_duration_seconds",
+			Help:      "Duration of broker operations by operation and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+		BrokerTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "broker_request_timeouts_total",
+			Help:      "Number of broker requests that timed out.",
+		}),
+		BrokerReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "broker_reconnects_total",
+			Help:      "Number of reconnects to the broker.",
+		}),
+
+		GatewayRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      "gateway_requests_total",
+			Help:      "Number of gateway requests by channel and status.",
+		}, []string{"channel", "status"}),
+		GatewayDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      "gateway_request_duration_seconds",
+			Help:      "Duration of gateway requests by channel.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"channel"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HandlerCalls,
+		m.HandlerDuration,
+		m.BrokerDuration,
+		m.BrokerTimeouts,
+		m.BrokerReconnects,
+		m.GatewayRequests,
+		m.GatewayDuration,
+	)
+
+	return m
+}
+
+// result returns the result label of the error.
+func result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+
+	return ResultSuccess
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how often a request is retried if it times out
+// or if there are no responders.
+// The backoff between two attempts grows exponentially and is randomly
+// reduced by the jitter to prevent clients from retrying simultaneously.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff that is randomly subtracted.
+	Jitter float64
+}
+
+// RequestOptions contains the configuration of a single request.
+type RequestOptions struct {
+	// Timeout limits the duration of each attempt.
+	Timeout time.Duration
+	Retry   *RetryPolicy
+}
+
+// RequestOption is a function on the options for a request.
+type RequestOption func(*RequestOptions)
+
+// WithTimeout is a RequestOption to set the timeout of each attempt.
+func WithTimeout(timeout time.Duration) RequestOption {
+	return func(o *RequestOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// WithRetry is a RequestOption to retry requests that were not received.
+func WithRetry(policy *RetryPolicy) RequestOption {
+	return func(o *RequestOptions) {
+		o.Retry = policy
+	}
+}
+
+// Attempts returns the number of attempts allowed by the policy.
+func (policy *RetryPolicy) Attempts() int {
+	if policy == nil || policy.MaxAttempts < 1 {
+		return 1
+	}
+
+	return policy.MaxAttempts
+}
+
+// Backoff returns the duration to wait after the given attempt.
+func (policy *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	backoff := float64(policy.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	backoff -= backoff * policy.Jitter * rand.Float64()
+
+	return time.Duration(backoff)
+}
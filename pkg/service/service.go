@@ -2,13 +2,16 @@ package service
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sys/unix"
 )
 
@@ -16,6 +19,23 @@ import (
 type Config struct {
 	Name    string
 	Version string
+	// GracePeriod limits how long in-flight requests and channel
+	// handlers may take to complete during a shutdown.
+	GracePeriod time.Duration
+	// AdminPort is the port of the admin endpoints, such as the
+	// health checks. The endpoints are disabled if it is empty.
+	AdminPort string
+	// TraceExporter is either `otlp` or `stdout`. Spans are not
+	// exported if it is empty.
+	TraceExporter string
+	// LogFormat is either `console` or `json`. Defaults to `console`.
+	LogFormat string
+	// LogLevel is the minimum level of logs, such as `debug` or
+	// `warn`. Defaults to `info`.
+	LogLevel string
+	// LogSampling only logs every n-th debug and info log if it
+	// is greater than one.
+	LogSampling uint32
 }
 
 // Service contains the state and configuration of a microservice.
@@ -24,40 +44,73 @@ type Service struct {
 	Broker  Broker
 	Gateway Gateway
 	Config  Config
-
-	signals   chan os.Signal
-	terminate chan bool
+	Metrics *Metrics
+	// Tracer starts the spans of the service. It does not
+	// record spans if no trace exporter is configured.
+	Tracer trace.Tracer
+
+	signals         chan os.Signal
+	terminate       chan int
+	broadcasts      map[string]bool
+	broadcastsMutex *sync.RWMutex
+	inflight        int64
+	stopping        int32
+	channels        []string
+	healthChecks    map[string]HealthCheck
+	admin           *http.Server
+	provider        *sdktrace.TracerProvider
 }
 
 // New returns a new service for the given configuration.
 func New(config Config) *Service {
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        os.Stderr,
-		TimeFormat: time.RFC3339,
-	})
+	logger, loggerErr := newLogger(config)
+
+	if config.GracePeriod == 0 {
+		config.GracePeriod = DefaultGracePeriod
+	}
 
 	// Create service instance.
 	svc := &Service{
-		Logger: &log.Logger,
-		Config: config,
+		Logger:  &logger,
+		Config:  config,
+		Metrics: newMetrics(),
+		Tracer:  trace.NewNoopTracerProvider().Tracer(config.Name),
+
+		signals:         make(chan os.Signal, 1),
+		terminate:       make(chan int, 1),
+		broadcasts:      make(map[string]bool),
+		broadcastsMutex: &sync.RWMutex{},
+		healthChecks:    make(map[string]HealthCheck),
+	}
 
-		signals:   make(chan os.Signal, 1),
-		terminate: make(chan bool, 1),
+	if loggerErr != nil {
+		svc.Logger.Fatal().Err(loggerErr).Msg("Failed to configure logging")
 	}
 
 	// Log basic service information.
 	svc.Logger.Info().Msgf("Service: %s", svc.Config.Name)
 	svc.Logger.Info().Msgf("Version: %s", svc.Config.Version)
 
+	// Export spans if configured.
+	if config.TraceExporter != "" {
+		provider, err := newTracerProvider(config)
+		if err != nil {
+			svc.Logger.Fatal().Err(err).Msg("Failed to configure tracing")
+		}
+		svc.provider = provider
+		svc.Tracer = provider.Tracer(config.Name)
+		svc.Logger.Info().Msgf("Trace exporter: %s", config.TraceExporter)
+	}
+
 	return svc
 }
 
 func (svc *Service) UseBroker(b Broker) *Service {
-	// Store reference to broker.
-	svc.Broker = b
+	// Store reference to broker, whose operations are recorded.
+	svc.Broker = &instrumentedBroker{Broker: b, service: svc}
 
 	// Pass service pointer to broker.
-	svc.Broker.Bind(svc)
+	b.Bind(svc)
 
 	// Return the service pointer to allow method chaining.
 	return svc
@@ -80,18 +133,58 @@ func (svc *Service) BrokerChannel(channel string, channelHandler ChannelHandler)
 		svc.Logger.Fatal().Err(ErrNoBrokerConfigured).Msg("Failed to register broker channel")
 	}
 
-	// Subscribe to broker channel.
-	if err := svc.Broker.Subscribe(channel, channelHandler); err != nil {
+	// Subscribe to broker channel. Errors are replied to the requester
+	// and the outcome of the handler is broadcast.
+	if err := svc.Broker.Subscribe(channel, svc.track(svc.instrument(channel, svc.broadcast(channel, svc.replyError(channel, channelHandler))))); err != nil {
 		svc.Logger.Fatal().Err(err).Msgf("Failed to register broker channel")
 	}
 
 	// Log the registered channel.
+	svc.channels = append(svc.channels, channel)
 	svc.Logger.Info().Msgf("Channel registered: %s", channel)
 
 	// Return the service pointer to allow method chaining.
 	return svc
 }
 
+func (svc *Service) PersistentBrokerChannel(channel PersistentChannel, channelHandler ChannelHandler) *Service {
+	// Ensure that a broker is configured when channels are defined.
+	if svc.Broker == nil {
+		svc.Logger.Fatal().Err(ErrNoBrokerConfigured).Msg("Failed to register broker channel")
+	}
+
+	// Declare the channel as persistent. Fall back to a regular
+	// channel if the broker does not support persistence.
+	if err := svc.Broker.Persist(channel); err != nil {
+		if err != ErrPersistenceUnsupported {
+			svc.Logger.Fatal().Err(err).Msg("Failed to declare persistent broker channel")
+		}
+		svc.Logger.Warn().Err(err).Msgf("Channel not persistent: %s", channel.Name)
+	}
+
+	// Return the service pointer to allow method chaining.
+	return svc.BrokerChannel(channel.Name, channelHandler)
+}
+
+func (svc *Service) BrokerStream(channel string, channelHandler ChannelHandler) *Service {
+	// Ensure that a broker is configured when channels are defined.
+	if svc.Broker == nil {
+		svc.Logger.Fatal().Err(ErrNoBrokerConfigured).Msg("Failed to register broker stream")
+	}
+
+	// Accept streams on broker channel.
+	if err := svc.Broker.SubscribeStream(channel, svc.track(svc.instrument(channel, channelHandler))); err != nil {
+		svc.Logger.Fatal().Err(err).Msgf("Failed to register broker stream")
+	}
+
+	// Log the registered stream.
+	svc.channels = append(svc.channels, channel)
+	svc.Logger.Info().Msgf("Stream registered: %s", channel)
+
+	// Return the service pointer to allow method chaining.
+	return svc
+}
+
 func (svc *Service) GatewayMiddleware(requestHandler RequestHandler) *Service {
 	// Ensure that a gateway is configured when endpoints are defined.
 	if svc.Gateway == nil {
@@ -111,6 +204,11 @@ func (svc *Service) Start() {
 	signal.Notify(svc.signals, syscall.SIGINT, syscall.SIGTERM)
 	go svc.awaitSignals()
 
+	// Expose admin endpoints if configured.
+	if svc.Config.AdminPort != "" {
+		svc.listenAdmin()
+	}
+
 	// Connect to broker if configured.
 	if svc.Broker != nil {
 		if err := svc.Broker.Connect(); err != nil {
@@ -123,8 +221,12 @@ func (svc *Service) Start() {
 		go svc.Gateway.Listen()
 	}
 
-	// Block until terminated.
-	<-svc.terminate
+	// Block until terminated and exit with a non-zero
+	// status if the shutdown was not graceful.
+	if code := <-svc.terminate; code != 0 {
+		os.Exit(code)
+	}
+	svc.Logger.Info().Msg("Terminated")
 }
 
 func (svc *Service) awaitSignals() {
@@ -140,11 +242,18 @@ func (svc *Service) awaitSignals() {
 	svc.Logger.Info().Msg("Signal received: " + sigName)
 	svc.Logger.Info().Msg("Terminating ...")
 
-	// Gracefully shut down broker connection.
-	if err := svc.Broker.Disconnect(); err != nil {
-		svc.Logger.Warn().Err(err).Msg("Failed to disconnect from broker gracefully")
+	// Terminate immediately if another signal is received.
+	go func() {
+		sig := <-svc.signals
+		svc.Logger.Warn().Msg("Signal received: " + unix.SignalName(sig.(syscall.Signal)))
+		svc.Logger.Warn().Msg("Terminating immediately ...")
+		os.Exit(1)
+	}()
+
+	// Terminate process after shutting down gracefully.
+	if err := svc.shutdown(); err != nil {
+		svc.terminate <- 1
+		return
 	}
-
-	// Terminate process.
-	svc.terminate <- true
+	svc.terminate <- 0
 }
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// This file contains the graceful shutdown of a service, which
+// happens in the following order once a signal is received:
+//
+//   1. The service reports that it is no longer ready.
+//   2. The gateway stops accepting traffic and completes in-flight requests.
+//   3. The channels are unsubscribed and unregistered, such that no new
+//      channel handlers are started, and in-flight channel handlers complete.
+//   4. The broker connection is drained.
+//   5. The remaining spans are exported and the admin listener is closed.
+//
+// The second and third step are limited by the grace period of the service.
+// If the grace period passes, the remaining steps are still performed,
+// but the service exits with a non-zero status.
+
+const (
+	DefaultGracePeriod = 15 * time.Second
+
+	// drainInterval is the interval to check for in-flight channel handlers.
+	drainInterval = 50 * time.Millisecond
+)
+
+var (
+	ErrGracePeriodExceeded = errors.New("service: grace period exceeded")
+)
+
+// track wraps the channel handler to count its in-flight invocations.
+func (svc *Service) track(channelHandler ChannelHandler) ChannelHandler {
+	return func(ctx *Context) error {
+		atomic.AddInt64(&svc.inflight, 1)
+		defer atomic.AddInt64(&svc.inflight, -1)
+
+		return channelHandler(ctx)
+	}
+}
+
+// shutdown stops the gateway, unsubscribes from the channels, waits for
+// in-flight channel handlers and disconnects from the broker. It returns
+// the first error.
+func (svc *Service) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), svc.Config.GracePeriod)
+	defer cancel()
+
+	// Fail readiness checks to stop receiving traffic.
+	atomic.StoreInt32(&svc.stopping, 1)
+
+	var shutdownErr error
+	if svc.Gateway != nil {
+		svc.Logger.Info().Msg("Stopping gateway ...")
+		if err := svc.Gateway.Shutdown(ctx); err != nil {
+			svc.Logger.Warn().Err(err).Msg("Failed to stop gateway gracefully")
+			shutdownErr = err
+		}
+	}
+
+	if svc.Broker != nil {
+		// Stop receiving events before waiting for the channel handlers,
+		// such that no channel handler is started after waiting.
+		svc.Logger.Info().Msg("Unsubscribing from channels ...")
+		for _, channel := range svc.channels {
+			if err := svc.Broker.Unsubscribe(channel); err != nil {
+				svc.Logger.Warn().Err(err).Msgf("Failed to unsubscribe from channel: %s", channel)
+			}
+		}
+
+		svc.Logger.Info().Msg("Waiting for channel handlers ...")
+		if err := svc.awaitHandlers(ctx); err != nil {
+			svc.Logger.Warn().Err(err).Msgf("Channel handlers still running: %d", atomic.LoadInt64(&svc.inflight))
+			if shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+
+		// Drain the connection.
+		svc.Logger.Info().Msg("Disconnecting from broker ...")
+		if err := svc.Broker.Disconnect(); err != nil {
+			svc.Logger.Warn().Err(err).Msg("Failed to disconnect from broker gracefully")
+			if shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+	}
+
+	// Export the remaining spans.
+	if svc.provider != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		if err := svc.provider.Shutdown(flushCtx); err != nil {
+			svc.Logger.Warn().Err(err).Msg("Failed to export spans")
+		}
+	}
+
+	if svc.admin != nil {
+		svc.admin.Close()
+	}
+
+	return shutdownErr
+}
+
+// awaitHandlers waits until no channel handler is running.
+func (svc *Service) awaitHandlers(ctx context.Context) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&svc.inflight) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ErrGracePeriodExceeded
+		}
+	}
+
+	return nil
+}
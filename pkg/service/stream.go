@@ -0,0 +1,30 @@
+package service
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrStreamsUnsupported = errors.New("broker: streams unsupported")
+	ErrStreamClosed       = errors.New("stream: write on closed stream")
+	ErrStreamCanceled     = errors.New("stream: canceled")
+	ErrStreamTimeout      = errors.New("stream: timed out")
+)
+
+// Stream is a long-lived, ordered and bi-directional point-to-point
+// connection between two services. Data is transferred in chunks,
+// whereby the writer is blocked if the reader does not keep up.
+type Stream interface {
+	// Read reads data sent by the peer. It returns io.EOF
+	// once the peer closed its side of the stream.
+	io.Reader
+	// Write sends data to the peer.
+	io.Writer
+
+	// CloseWrite closes the sending side of the stream. The
+	// stream remains readable until the peer closes it as well.
+	CloseWrite() error
+	// Cancel aborts the stream in both directions.
+	Cancel() error
+}
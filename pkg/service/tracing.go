@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// This file contains the distributed tracing of a service. The trace
+// context is propagated between services via the distributed tracing
+// extension of cloud events, which carries the W3C `traceparent` and
+// `tracestate`. Spans are exported via OTLP or printed to stdout for
+// local testing, whereby the OTLP exporter is configured via the
+// standard environment variables, such as `OTEL_EXPORTER_OTLP_ENDPOINT`.
+
+const (
+	// ExtensionTraceParent is the cloud event extension that contains
+	// the W3C trace context of the span that published the event.
+	ExtensionTraceParent = "traceparent"
+	// ExtensionTraceState is the cloud event extension that contains
+	// vendor-specific trace information.
+	ExtensionTraceState = "tracestate"
+
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+
+	// traceFlushTimeout limits how long the remaining spans are
+	// exported for when the service shuts down.
+	traceFlushTimeout = 5 * time.Second
+)
+
+var (
+	ErrUnknownTraceExporter = errors.New("service: unknown trace exporter")
+)
+
+// traceContext propagates the trace context in the W3C format.
+var traceContext = propagation.TraceContext{}
+
+// eventCarrier stores the trace context in the extensions of a cloud event.
+type eventCarrier struct {
+	event *cloudevents.Event
+}
+
+func (c eventCarrier) Get(key string) string {
+	value, _ := types.ToString(c.event.Extensions()[key])
+	return value
+}
+
+func (c eventCarrier) Set(key string, value string) {
+	c.event.SetExtension(key, value)
+}
+
+func (c eventCarrier) Keys() []string {
+	return []string{ExtensionTraceParent, ExtensionTraceState}
+}
+
+// InjectTraceContext stores the trace context of the span in the context
+// in the event. Events of contexts without a span are not modified.
+func InjectTraceContext(ctx context.Context, event *cloudevents.Event) {
+	traceContext.Inject(ctx, eventCarrier{event: event})
+}
+
+// ExtractTraceContext returns a copy of the context, which
+// continues the trace that is stored in the event.
+func ExtractTraceContext(ctx context.Context, event *cloudevents.Event) context.Context {
+	return traceContext.Extract(ctx, eventCarrier{event: event})
+}
+
+// TraceID returns the ID of the trace of the span in the context. It
+// returns an empty string if the context does not belong to a trace.
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
+
+// newTracerProvider creates a tracer provider, which exports
+// the spans of the service via the configured exporter.
+func newTracerProvider(config Config) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TraceExporter {
+	case TraceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TraceExporterOTLP:
+		exporter, err = otlptracegrpc.New(context.Background())
+	default:
+		return nil, ErrUnknownTraceExporter
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(config.Name),
+			semconv.ServiceVersion(config.Version),
+		)),
+	), nil
+}
+
+// UserContext returns the context of the channel handler, which contains
+// its span. It should be passed to the broker when publishing events or
+// sending requests to continue the trace.
+func (ctx *Context) UserContext() context.Context {
+	if ctx.userContext == nil {
+		return context.Background()
+	}
+
+	return ctx.userContext
+}
+
+// startHandlerSpan starts the span of the channel handler as child of the
+// span that published the event and stores it in the context of the handler.
+func (svc *Service) startHandlerSpan(channel string, ctx *Context) trace.Span {
+	parent := ExtractTraceContext(ctx.UserContext(), ctx.Cloudevent)
+	userContext, span := svc.Tracer.Start(parent, channel,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.CloudeventsEventID(ctx.Cloudevent.ID()),
+			semconv.CloudeventsEventSource(ctx.Cloudevent.Source()),
+			semconv.CloudeventsEventType(ctx.Cloudevent.Type()),
+		),
+	)
+	ctx.userContext = userContext
+
+	return span
+}